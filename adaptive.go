@@ -0,0 +1,163 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultAdaptiveInterval   = 10 * time.Second
+	defaultAdaptiveTargetWait = time.Second
+)
+
+// AdaptivePrefetchParams let the client tune prefetch of a consumer by its handler latency.
+//
+// Every Interval the client measures average handler latency and throughput, and sets prefetch so that
+// all workers stay busy, and a prefetched delivery waits for a worker about TargetWait:
+//
+//	prefetch = workers + workers / latency * TargetWait
+//
+// Too large prefetch for slow handlers leads to deliveries waiting in the client longer than
+// broker's consumer_timeout, and too small one for fast handlers leaves workers idle.
+//
+// Adaptive consumers always get a dedicated channel, because prefetch of an existing consumer
+// can only be changed by channel-wide (global) Qos.
+type AdaptivePrefetchParams struct {
+	Min int
+	Max int
+	// 1 second when not set
+	TargetWait time.Duration
+	// 10 seconds when not set
+	Interval time.Duration
+	// optional callback invoked after every prefetch change
+	AdjustCallback func(PrefetchAdjustment)
+}
+
+// PrefetchAdjustment describes a change of consumer prefetch
+type PrefetchAdjustment struct {
+	ConsumerID string
+	From       int
+	To         int
+	// measured during the last interval
+	AvgLatency time.Duration
+	// handled deliveries per second
+	Throughput float64
+}
+
+type latencyMeter struct {
+	handled atomic.Int64
+	total   atomic.Int64 // nanoseconds
+}
+
+func (m *latencyMeter) record(elapsed time.Duration, handled int) {
+	m.handled.Add(int64(handled))
+	m.total.Add(int64(elapsed))
+}
+
+// reset returns measurements since the previous reset
+func (m *latencyMeter) reset() (int64, time.Duration) {
+	return m.handled.Swap(0), time.Duration(m.total.Swap(0))
+}
+
+func validateAdaptivePrefetch(consumer *managedConsumer) error {
+	params := consumer.AdaptivePrefetch
+	if params.Min <= 0 || params.Max < params.Min {
+		return fmt.Errorf("adaptive prefetch of consumer %s requires 0 < Min <= Max", consumer.ConsumerID)
+	}
+	if consumer.IBatchConsumer != nil && params.Min < consumer.BatchSize {
+		return fmt.Errorf("adaptive prefetch Min of consumer %s is less than batch size", consumer.ConsumerID)
+	}
+	return nil
+}
+
+// adaptPrefetch forwards deliveries and adjusts prefetch of the channel every interval,
+// it stops together with the deliveries channel
+func (c *Client) adaptPrefetch(ch *amqp.Channel, consumer *managedConsumer, deliveries <-chan amqp.Delivery) <-chan amqp.Delivery {
+	forwarded := make(chan amqp.Delivery)
+	stopped := make(chan struct{})
+	c.spawn(consumer, func() {
+		defer close(forwarded)
+		defer close(stopped)
+
+		for msg := range deliveries {
+			forwarded <- msg
+		}
+	})
+
+	// adjustments run on their own, forwarding blocks while all workers are busy
+	c.spawn(consumer, func() {
+		c.adjustPrefetch(ch, consumer, stopped)
+	})
+
+	return forwarded
+}
+
+func (c *Client) adjustPrefetch(ch *amqp.Channel, consumer *managedConsumer, stopped <-chan struct{}) {
+	params := consumer.AdaptivePrefetch
+	interval := params.Interval
+	if interval <= 0 {
+		interval = defaultAdaptiveInterval
+	}
+	targetWait := params.TargetWait
+	if targetWait <= 0 {
+		targetWait = defaultAdaptiveTargetWait
+	}
+	// only prefetch count adapts, the rest of Qos stays as configured
+	prefetchSize := consumer.qos(c.cfg).PrefetchSize
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// measurements of the previous subscription are irrelevant
+	_, _ = consumer.latency.reset()
+	lastTick := time.Now()
+
+	for {
+		var now time.Time
+		select {
+		case <-stopped:
+			return
+		case now = <-ticker.C:
+		}
+
+		handled, total := consumer.latency.reset()
+		elapsed := now.Sub(lastTick)
+		lastTick = now
+		if handled == 0 {
+			// idle consumer tells nothing about its handlers
+			continue
+		}
+
+		avgLatency := total / time.Duration(handled)
+		to := adaptedPrefetch(params, consumer.workers(), avgLatency, targetWait)
+
+		from := int(consumer.prefetch.Load())
+		if to == from {
+			continue
+		}
+		if err := ch.Qos(to, prefetchSize, true); err != nil {
+			consumer.recordErr(err)
+			continue
+		}
+		consumer.prefetch.Store(int64(to))
+
+		if params.AdjustCallback != nil {
+			params.AdjustCallback(PrefetchAdjustment{
+				ConsumerID: consumer.ConsumerID,
+				From:       from,
+				To:         to,
+				AvgLatency: avgLatency,
+				Throughput: float64(handled) / elapsed.Seconds(),
+			})
+		}
+	}
+}
+
+// adaptedPrefetch keeps workers busy and a prefetched delivery waiting about targetWait, within Min and Max
+func adaptedPrefetch(params *AdaptivePrefetchParams, workers int, avgLatency, targetWait time.Duration) int {
+	target := float64(workers) + float64(workers)*targetWait.Seconds()/max(avgLatency.Seconds(), 1e-6)
+	return min(max(int(math.Ceil(target)), params.Min), params.Max)
+}
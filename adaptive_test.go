@@ -0,0 +1,48 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptedPrefetch(t *testing.T) {
+	params := &AdaptivePrefetchParams{Min: 2, Max: 100}
+
+	tests := []struct {
+		name       string
+		workers    int
+		avgLatency time.Duration
+		want       int
+	}{
+		{name: "slow handler", workers: 4, avgLatency: 500 * time.Millisecond, want: 12},
+		{name: "handler as slow as target wait", workers: 1, avgLatency: time.Second, want: 2},
+		{name: "handler slower than Min allows", workers: 1, avgLatency: time.Minute, want: 2},
+		{name: "fast handler capped by Max", workers: 4, avgLatency: time.Millisecond, want: 100},
+		{name: "zero latency", workers: 1, avgLatency: 0, want: 100},
+	}
+	for _, tt := range tests {
+		if got := adaptedPrefetch(params, tt.workers, tt.avgLatency, time.Second); got != tt.want {
+			t.Errorf("%s: adaptedPrefetch() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAdaptivePrefetch(t *testing.T) {
+	for name, params := range map[string]AdaptivePrefetchParams{
+		"zero Min":      {Max: 10},
+		"Max below Min": {Min: 10, Max: 5},
+	} {
+		consumer := newManagedConsumer(AMQPConsumer{ConsumerParams: ConsumerParams{AdaptivePrefetch: &params}})
+		if err := validateAdaptivePrefetch(consumer); err == nil {
+			t.Errorf("%s: validateAdaptivePrefetch() succeeded", name)
+		}
+	}
+
+	batch := newManagedConsumer(AMQPConsumer{
+		ConsumerParams: ConsumerParams{BatchSize: 10, AdaptivePrefetch: &AdaptivePrefetchParams{Min: 5, Max: 20}},
+		IBatchConsumer: BatchConsumerFunc(nil),
+	})
+	if err := validateAdaptivePrefetch(batch); err == nil {
+		t.Error("validateAdaptivePrefetch() accepted Min below batch size")
+	}
+}
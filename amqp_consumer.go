@@ -4,15 +4,24 @@
 // - https://www.rabbitmq.com/documentation.html
 package rabbitmq
 
-import amqp "github.com/rabbitmq/amqp091-go"
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
 
 type AMQPConsumer struct {
 	ExchangeParams
 	QueueParams
 	QueueBindParams
 	ConsumerParams
+	QosParams
+	// custom field: optional dead-letter exchange and queue declared together with the queue
+	DeadLetter *DeadLetterParams
 
 	IConsumer
+	// set either IConsumer or IBatchConsumer, the latter always gets a dedicated channel
+	IBatchConsumer
 }
 
 type ExchangeParams struct {
@@ -49,4 +58,69 @@ type ConsumerParams struct {
 	NoLocal     bool
 	Nowait      bool
 	Args        amqp.Table
+	// custom field: number of goroutines handling deliveries concurrently, 1 when not set.
+	// If ClientConfig.ConsumerQos is not set, prefetch of this consumer is set to Concurrency.
+	Concurrency int
+	// custom field: when set, deliveries are hashed by the key into Concurrency lanes,
+	// deliveries with the same key are handled strictly in order while different lanes run in parallel
+	PartitionKey PartitionKeyFunc
+	// custom field: how long a lane waits for a handled delivery to be settled before it moves on,
+	// giving up the order of that delivery. Forever when not set, so deliveries left unsettled on purpose,
+	// e.g. buffered chunks (mqutils.NewChunkReassemblyMiddleware) or mqutils.DeferAck, stall their lane.
+	PartitionSettleTimeout time.Duration
+	// custom field: consumer gets its own channel with its own QosParams, so that a channel error of this consumer
+	// doesn't affect others and vice versa. Such channel is recovered on its own.
+	DedicatedChannel bool
+	// custom field: handler context is cancelled after this timeout
+	HandlerTimeout time.Duration
+	// custom field: handler context deadline is set to the message expiration (see MessageDeadline)
+	ExpirationDeadline bool
+	// custom fields: IBatchConsumer gets up to BatchSize deliveries at once, or less if BatchTimeout
+	// has passed since the first of them (1 second when not set). Prefetch must not be less than BatchSize.
+	BatchSize    int
+	BatchTimeout time.Duration
+	// custom field: consume a stream queue tracking offsets, see StreamParams
+	Stream *StreamParams
+	// custom field: prefetch follows handler latency within bounds, see AdaptivePrefetchParams
+	AdaptivePrefetch *AdaptivePrefetchParams
+}
+
+// DeadLetterParams make the client declare a fanout dead-letter exchange and a queue bound to it,
+// and set x-dead-letter-exchange argument of the consumer queue. Names follow mqutils naming conventions
+// (see mqutils.NewDeadLetterExchangeName and mqutils.NewDeadLetterQueueName) unless set explicitly.
+//
+// Queue arguments can't be changed once the queue exists: declaring an existing queue without the argument
+// fails with PRECONDITION_FAILED and closes the channel. For such queues declare the dead-letter exchange
+// and queue by hand and set dead-lettering by a policy instead:
+//
+//	rabbitmqctl set_policy orders-dlx "^orders$" '{"dead-letter-exchange":"orders.dlx"}' --apply-to queues
+type DeadLetterParams struct {
+	// "{queue}.dlx" when not set
+	Exchange string
+	// "{queue}.dlq" when not set
+	Queue string
+	// optional arguments of the dead-letter queue, e.g. x-queue-type or x-message-ttl
+	QueueArgs amqp.Table
+}
+
+func (p DeadLetterParams) exchange(queue string) string {
+	if p.Exchange != "" {
+		return p.Exchange
+	}
+	return queue + ".dlx"
+}
+
+func (p DeadLetterParams) queue(queue string) string {
+	if p.Queue != "" {
+		return p.Queue
+	}
+	return queue + ".dlq"
+}
+
+// QosParams are applied to the dedicated channel of a consumer, ClientConfig.Consumer* values are used when not set.
+// On the shared channel only PrefetchCount is applied, and to this consumer only.
+type QosParams struct {
+	PrefetchCount int
+	PrefetchSize  int
+	Global        bool
 }
@@ -0,0 +1,165 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultBatchTimeout = time.Second
+
+// An IBatchConsumer handles deliveries in batches.
+//
+// Unlike IConsumer it doesn't settle deliveries itself, the client does it depending on the returned error:
+//   - nil: the whole batch is acknowledged with a single `multiple` ack
+//   - *BatchError: listed deliveries are nacked one by one, the rest are acknowledged
+//   - any other error: the whole batch is nacked with requeue
+type IBatchConsumer interface {
+	ConsumeBatch(ctx context.Context, msgs []amqp.Delivery) error
+}
+
+type BatchConsumerFunc func(ctx context.Context, msgs []amqp.Delivery) error
+
+func (f BatchConsumerFunc) ConsumeBatch(ctx context.Context, msgs []amqp.Delivery) error {
+	return f(ctx, msgs)
+}
+
+// BatchFailure is a delivery of a batch which failed, Index points into the slice passed to ConsumeBatch
+type BatchFailure struct {
+	Index   int
+	Requeue bool
+	Err     error
+}
+
+// BatchError reports deliveries of a batch which failed, others are considered successful
+type BatchError struct {
+	Failures []BatchFailure
+}
+
+func (err *BatchError) Error() string {
+	return fmt.Sprintf("%d deliveries of the batch failed", len(err.Failures))
+}
+
+func (err *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(err.Failures))
+	for _, failure := range err.Failures {
+		errs = append(errs, failure.Err)
+	}
+	return errs
+}
+
+// validateBatchConsumer checks settings batch consumers rely on
+func validateBatchConsumer(consumer *managedConsumer, cfg ClientConfig) error {
+	if consumer.AutoAck {
+		return fmt.Errorf("batch consumer %s requires manual acknowledgement", consumer.ConsumerID)
+	}
+	if consumer.Concurrency > 1 {
+		return fmt.Errorf("batch consumer %s can't have concurrency above 1", consumer.ConsumerID)
+	}
+	if consumer.PartitionKey != nil {
+		return fmt.Errorf("batch consumer %s can't be partitioned", consumer.ConsumerID)
+	}
+	// a batch is never filled otherwise
+	if prefetch := consumer.qos(cfg).PrefetchCount; prefetch > 0 && prefetch < consumer.BatchSize {
+		return fmt.Errorf("prefetch %d of batch consumer %s is less than batch size %d",
+			prefetch, consumer.ConsumerID, consumer.BatchSize)
+	}
+	return nil
+}
+
+// startBatching collects deliveries into batches of BatchSize, or less if BatchTimeout passes since the first one
+func (c *Client) startBatching(consumer *managedConsumer, deliveries <-chan amqp.Delivery) {
+	size := max(consumer.BatchSize, 1)
+	timeout := consumer.BatchTimeout
+	if timeout <= 0 {
+		timeout = defaultBatchTimeout
+	}
+
+	c.spawn(consumer, func() {
+		var batch []amqp.Delivery
+		var timer *time.Timer
+		var timeoutC <-chan time.Time
+
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, timeoutC = nil, nil
+			}
+			if len(batch) > 0 {
+				c.handleBatch(consumer, batch)
+				batch = nil
+			}
+		}
+
+		for {
+			select {
+			case msg, ok := <-deliveries:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, msg)
+				if len(batch) == 1 {
+					timer = time.NewTimer(timeout)
+					timeoutC = timer.C
+				}
+				if len(batch) >= size {
+					flush()
+				}
+			case <-timeoutC:
+				flush()
+			}
+		}
+	})
+}
+
+func (c *Client) handleBatch(consumer *managedConsumer, batch []amqp.Delivery) {
+	ctx, cancel := c.handlerContext(consumer, batch[0])
+	defer cancel()
+
+	consumer.deliveries.Add(uint64(len(batch)))
+	consumer.busyWorkers.Add(1)
+	start := time.Now()
+	err := consumer.ConsumeBatch(ctx, batch)
+	consumer.latency.record(time.Since(start), len(batch))
+	consumer.busyWorkers.Add(-1)
+
+	if err := settleBatch(batch, err); err != nil {
+		consumer.recordErr(err)
+	}
+}
+
+// settleBatch relies on the dedicated channel of the consumer: `multiple` ack settles all earlier deliveries
+// of the channel, which are either in this batch or already settled
+func settleBatch(batch []amqp.Delivery, consumeErr error) error {
+	last := batch[len(batch)-1]
+	if consumeErr == nil {
+		return last.Ack(true)
+	}
+
+	var batchErr *BatchError
+	if !errors.As(consumeErr, &batchErr) {
+		return last.Nack(true, true)
+	}
+
+	failed := make(map[int]bool, len(batchErr.Failures))
+	var err error
+	for _, failure := range batchErr.Failures {
+		if failure.Index < 0 || failure.Index >= len(batch) || failed[failure.Index] {
+			continue
+		}
+		failed[failure.Index] = true
+		err = errors.Join(err, batch[failure.Index].Nack(false, failure.Requeue))
+	}
+
+	// acknowledge up to the last successful delivery, failed ones before it are nacked already
+	for i := len(batch) - 1; i >= 0; i-- {
+		if !failed[i] {
+			return errors.Join(err, batch[i].Ack(true))
+		}
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recordingAcknowledger keeps settlements in the order they were made
+type recordingAcknowledger struct {
+	calls []string
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.calls = append(a.calls, fmt.Sprintf("ack %d multiple=%t", tag, multiple))
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.calls = append(a.calls, fmt.Sprintf("nack %d multiple=%t requeue=%t", tag, multiple, requeue))
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.calls = append(a.calls, fmt.Sprintf("reject %d requeue=%t", tag, requeue))
+	return nil
+}
+
+func testBatch(ack amqp.Acknowledger, size int) []amqp.Delivery {
+	batch := make([]amqp.Delivery, size)
+	for i := range batch {
+		batch[i] = amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1)}
+	}
+	return batch
+}
+
+func TestSettleBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{
+			name: "success acks the whole batch at once",
+			want: []string{"ack 4 multiple=true"},
+		},
+		{
+			name: "plain error requeues the whole batch",
+			err:  errors.New("failed"),
+			want: []string{"nack 4 multiple=true requeue=true"},
+		},
+		{
+			name: "failures are nacked and the rest acked up to the last successful one",
+			err: &BatchError{Failures: []BatchFailure{
+				{Index: 1, Requeue: true},
+				{Index: 3},
+			}},
+			want: []string{
+				"nack 2 multiple=false requeue=true",
+				"nack 4 multiple=false requeue=false",
+				"ack 3 multiple=true",
+			},
+		},
+		{
+			name: "duplicate and out of range failures are ignored",
+			err: &BatchError{Failures: []BatchFailure{
+				{Index: 0},
+				{Index: 0, Requeue: true},
+				{Index: -1},
+				{Index: 4},
+			}},
+			want: []string{
+				"nack 1 multiple=false requeue=false",
+				"ack 4 multiple=true",
+			},
+		},
+		{
+			name: "all failed",
+			err: fmt.Errorf("wrapped: %w", &BatchError{Failures: []BatchFailure{
+				{Index: 0}, {Index: 1}, {Index: 2}, {Index: 3},
+			}}),
+			want: []string{
+				"nack 1 multiple=false requeue=false",
+				"nack 2 multiple=false requeue=false",
+				"nack 3 multiple=false requeue=false",
+				"nack 4 multiple=false requeue=false",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &recordingAcknowledger{}
+			if err := settleBatch(testBatch(ack, 4), tt.err); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ack.calls, tt.want) {
+				t.Errorf("settled %q, want %q", ack.calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package rabbitmq
+
+import (
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultResubscribeAttempts = 5
+	// bounds of the interval between attempts to restore a consumer, AutoRecoveryInterval may be zero
+	minResubscribeBackoff = 100 * time.Millisecond
+	maxResubscribeBackoff = time.Minute
+)
+
+// ErrConsumerCancelledByBroker is reported to ConsumerAutoRecoveryErrCallback,
+// when a consumer can't be resubscribed after broker cancelled it
+var ErrConsumerCancelledByBroker = errors.New("consumer cancelled by broker")
+
+// watchCancellations resubscribes consumers of the channel cancelled by broker (basic.cancel),
+// which happens when a queue is deleted or a leader of a quorum (or mirrored) queue moves to another node.
+// The connection stays healthy in such cases, so reconnect would never restore those consumers.
+func (c *Client) watchCancellations(ch *amqp.Channel) {
+	// listener must not block, otherwise the whole channel stalls
+	cancellations := ch.NotifyCancel(make(chan string, 16))
+
+	go func() {
+		for consumerID := range cancellations {
+			consumer, err := c.findConsumer(consumerID)
+			if err != nil {
+				continue
+			}
+			go c.resubscribe(ch, consumer, consumer.subscriptions.Load())
+		}
+	}()
+}
+
+// resubscribe restores the consumer cancelled while it had the given number of subscriptions
+func (c *Client) resubscribe(ch *amqp.Channel, consumer *managedConsumer, subscriptions uint64) {
+	attempts := c.cfg.ConsumerResubscribeAttempts
+	if attempts <= 0 {
+		attempts = defaultResubscribeAttempts
+	}
+	backoff := max(c.cfg.AutoRecoveryInterval, minResubscribeBackoff)
+
+	for attempt := 1; ; attempt++ {
+		// deliveries channel is already closed, let workers drain it
+		consumer.wg.Wait()
+
+		restored, err := c.resubscribeOnce(ch, consumer, subscriptions)
+		if restored {
+			return
+		}
+
+		if attempt >= attempts {
+			c.cfg.ConsumerAutoRecoveryErrCallback(consumer.AMQPConsumer, errors.Join(ErrConsumerCancelledByBroker, err))
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxResubscribeBackoff)
+	}
+}
+
+// resubscribeOnce subscribes the consumer on the channel again unless it doesn't need it anymore
+func (c *Client) resubscribeOnce(ch *amqp.Channel, consumer *managedConsumer, subscriptions uint64) (bool, error) {
+	consumer.subscribeMx.Lock()
+	defer consumer.subscribeMx.Unlock()
+
+	// paused or cancelled consumers are not resubscribed, as well as those whose channel is gone:
+	// they are restored along with the channel. Neither those subscribed again meanwhile (e.g. by Resume),
+	// since a duplicate consumer tag closes the channel. Checks are made under subscribeMx,
+	// so Pause, Resume or reconnect can't change the consumer until it's subscribed.
+	if status := consumer.getStatus(); status == ConsumerPaused || status == ConsumerCancelled ||
+		ch.IsClosed() || c.consumerChannel(consumer) != ch || consumer.subscriptions.Load() != subscriptions {
+		return true, nil
+	}
+
+	// queue may have been deleted, so declare it again, but on a separate channel:
+	// a failed declaration closes the channel it is made on
+	err := c.WithChannel(func(topologyChan *amqp.Channel) error {
+		return declareTopology(topologyChan, consumer.AMQPConsumer)
+	})
+	if err == nil {
+		err = c.startConsuming(ch, consumer)
+	}
+	consumer.setErr(err)
+	return err == nil, err
+}
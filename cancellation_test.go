@@ -0,0 +1,29 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestResubscribeSkipsConsumersWhichDontNeedIt(t *testing.T) {
+	cancelled := &amqp.Channel{}
+
+	tests := map[string]func(client *Client, consumer *managedConsumer){
+		"paused":           func(_ *Client, consumer *managedConsumer) { consumer.setStatus(ConsumerPaused) },
+		"cancelled":        func(_ *Client, consumer *managedConsumer) { consumer.setStatus(ConsumerCancelled) },
+		"channel replaced": func(client *Client, _ *managedConsumer) { client.consumerChan = &amqp.Channel{} },
+		"resubscribed":     func(_ *Client, consumer *managedConsumer) { consumer.subscriptions.Add(1) },
+	}
+	for name, change := range tests {
+		// the client has no connection, so an attempt to subscribe would panic
+		client := &Client{consumerChan: cancelled}
+		consumer := newTestConsumer(client, "c1")
+		subscriptions := consumer.subscriptions.Load()
+		change(client, consumer)
+
+		if restored, err := client.resubscribeOnce(cancelled, consumer, subscriptions); !restored || err != nil {
+			t.Errorf("%s: resubscribeOnce() = %t, %v, want skipped", name, restored, err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -30,6 +31,14 @@ type ClientConfig struct {
 	ConsumerQos          int
 	ConsumerPrefetchSize int
 	ConsumerGlobal       bool
+
+	// number of attempts to resubscribe a consumer cancelled by broker (e.g. its queue was deleted),
+	// ConsumerAutoRecoveryErrCallback is called when all of them fail. 5 attempts when not set.
+	ConsumerResubscribeAttempts int
+
+	// handler contexts are cancelled on Close, but not earlier than ShutdownGracePeriod after it,
+	// letting in-flight handlers finish their work
+	ShutdownGracePeriod time.Duration
 }
 
 type Client struct {
@@ -38,7 +47,11 @@ type Client struct {
 	publisherChan *amqp.Channel
 	consumerChan  *amqp.Channel
 
-	consumers []AMQPConsumer
+	consumers []*managedConsumer
+
+	// base context of all handlers, cancelled on Close
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	mx sync.RWMutex
 	wg sync.WaitGroup
@@ -46,7 +59,9 @@ type Client struct {
 
 func NewClient(cfg ClientConfig) (*Client, error) {
 	client := Client{cfg: cfg}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
 	if err := client.connect(); err != nil {
+		client.cancel()
 		return nil, err
 	}
 
@@ -89,6 +104,8 @@ func (c *Client) connect() error {
 	c.publisherChan = publisherChannel
 	c.consumerChan = consumerChannel
 
+	c.watchCancellations(consumerChannel)
+
 	go func() {
 		errNotifyChan := c.connection.NotifyClose(make(chan *amqp.Error))
 
@@ -103,7 +120,7 @@ func (c *Client) connect() error {
 }
 
 func (c *Client) reconnect() {
-	if err := c.Close(); err != nil {
+	if err := c.close(); err != nil {
 		shouldContinue := c.cfg.AutoRecoveryErrCallback(err)
 		if !shouldContinue {
 			return
@@ -121,9 +138,12 @@ func (c *Client) reconnect() {
 		}
 	} else {
 		// connection was successful, so restore consumers
-		for _, consumerParam := range c.consumers {
-			if err := c.consume(consumerParam); err != nil {
-				c.cfg.ConsumerAutoRecoveryErrCallback(consumerParam, err)
+		c.mx.RLock()
+		defer c.mx.RUnlock()
+
+		for _, consumer := range c.consumers {
+			if err := c.consume(consumer); err != nil {
+				c.cfg.ConsumerAutoRecoveryErrCallback(consumer.AMQPConsumer, err)
 			}
 		}
 	}
@@ -133,14 +153,101 @@ func (c *Client) Consume(consumer AMQPConsumer) error {
 	c.mx.Lock()
 	defer c.mx.Unlock()
 
-	c.consumers = append(c.consumers, consumer)
+	// offsets of a stream consumer are stored by its id, a generated one would be lost on restart
+	if consumer.Stream != nil && consumer.ConsumerID == "" {
+		return errors.New("stream consumer requires ConsumerID")
+	}
+	// consumer tag must be known in order to cancel the consumer later
+	if consumer.ConsumerID == "" {
+		consumer.ConsumerID = "ctag-" + uuid.NewString()
+	}
+	for _, registered := range c.consumers {
+		if registered.ConsumerID == consumer.ConsumerID {
+			return fmt.Errorf("consumer %s is already registered", consumer.ConsumerID)
+		}
+	}
+
+	// dead-letter names are derived from the queue name, which is unknown for server-named queues
+	if consumer.DeadLetter != nil && consumer.QueueParams.Name == "" &&
+		(consumer.DeadLetter.Exchange == "" || consumer.DeadLetter.Queue == "") {
+		return fmt.Errorf("dead-letter names of consumer %s require queue name", consumer.ConsumerID)
+	}
+	if _, ok := consumer.QueueParams.Args["x-dead-letter-exchange"]; ok && consumer.DeadLetter != nil {
+		return fmt.Errorf("consumer %s sets both DeadLetter and x-dead-letter-exchange queue argument", consumer.ConsumerID)
+	}
+
+	managed := newManagedConsumer(consumer)
+	if managed.AdaptivePrefetch != nil {
+		managed.DedicatedChannel = true
+		if err := validateAdaptivePrefetch(managed); err != nil {
+			return err
+		}
+	}
+	if managed.IBatchConsumer != nil {
+		// batches are acknowledged with `multiple` flag, which must not touch deliveries of other consumers
+		managed.DedicatedChannel = true
+		if err := validateBatchConsumer(managed, c.cfg); err != nil {
+			return err
+		}
+	}
+	if managed.Stream != nil {
+		if err := validateStreamConsumer(managed, c.cfg); err != nil {
+			return err
+		}
+	}
+	c.consumers = append(c.consumers, managed)
+
+	return c.consume(managed)
+}
+
+func (c *Client) consume(consumer *managedConsumer) error {
+	consumer.subscribeMx.Lock()
+	defer consumer.subscribeMx.Unlock()
+
+	err := c.subscribe(consumer)
+	consumer.setErr(err)
+	return err
+}
+
+func (c *Client) subscribe(consumer *managedConsumer) error {
+	ch := c.consumerChan
+	if consumer.DedicatedChannel {
+		dedicated, err := c.openDedicatedChannel(consumer)
+		if err != nil {
+			return err
+		}
+		ch = dedicated
+	}
+
+	if err := declareTopology(ch, consumer.AMQPConsumer); err != nil {
+		return err
+	}
+
+	// paused consumers keep their topology, but don't consume until resumed
+	if consumer.consumes() && consumer.getStatus() != ConsumerPaused {
+		return c.startConsuming(ch, consumer)
+	}
 
-	return c.consume(consumer)
+	return nil
 }
 
-func (c *Client) consume(consumer AMQPConsumer) error {
+func declareTopology(ch *amqp.Channel, consumer AMQPConsumer) error {
+	queueArgs := consumer.QueueParams.Args
+	if consumer.DeadLetter != nil {
+		exchange, err := declareDeadLetter(ch, consumer)
+		if err != nil {
+			return err
+		}
+		// caller's table must not be mutated
+		queueArgs = amqp.Table{}
+		for k, v := range consumer.QueueParams.Args {
+			queueArgs[k] = v
+		}
+		queueArgs["x-dead-letter-exchange"] = exchange
+	}
+
 	if consumer.DeclareExchange {
-		if err := c.consumerChan.ExchangeDeclare(
+		if err := ch.ExchangeDeclare(
 			consumer.ExchangeParams.Name,
 			consumer.ExchangeParams.Type,
 			consumer.ExchangeParams.Durable,
@@ -153,13 +260,13 @@ func (c *Client) consume(consumer AMQPConsumer) error {
 		}
 	}
 
-	queue, err := c.consumerChan.QueueDeclare(
+	queue, err := ch.QueueDeclare(
 		consumer.QueueParams.Name,
 		consumer.QueueParams.Durable,
 		consumer.QueueParams.AutoDelete,
 		consumer.QueueParams.Exclusive,
 		consumer.QueueParams.Nowait,
-		consumer.QueueParams.Args,
+		queueArgs,
 	)
 	if err != nil {
 		return err
@@ -170,7 +277,7 @@ func (c *Client) consume(consumer AMQPConsumer) error {
 		// It has one special property that makes it very useful for simple applications:
 		// every queue that is created is automatically bound to it with a routing event which is the same as the queue name.
 		if consumer.ExchangeParams.Name != "" {
-			if err := c.consumerChan.QueueBind(
+			if err := ch.QueueBind(
 				queue.Name,
 				key,
 				consumer.ExchangeParams.Name,
@@ -182,31 +289,167 @@ func (c *Client) consume(consumer AMQPConsumer) error {
 		}
 	}
 
-	if consumer.IConsumer != nil {
-		deliveries, err := c.consumerChan.Consume(
-			consumer.QueueParams.Name,
-			consumer.ConsumerParams.ConsumerID,
-			consumer.ConsumerParams.AutoAck,
-			consumer.ConsumerParams.Exclusive,
-			consumer.ConsumerParams.NoLocal,
-			consumer.ConsumerParams.Nowait,
-			consumer.ConsumerParams.Args,
-		)
+	return nil
+}
+
+// declareDeadLetter declares dead-letter exchange and queue of the consumer and returns name of the exchange
+func declareDeadLetter(ch *amqp.Channel, consumer AMQPConsumer) (string, error) {
+	exchange := consumer.DeadLetter.exchange(consumer.QueueParams.Name)
+	queue := consumer.DeadLetter.queue(consumer.QueueParams.Name)
+
+	if err := ch.ExchangeDeclare(exchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return "", err
+	}
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, consumer.DeadLetter.QueueArgs); err != nil {
+		return "", err
+	}
+	if err := ch.QueueBind(queue, "", exchange, false, nil); err != nil {
+		return "", err
+	}
+	return exchange, nil
+}
+
+func (c *Client) startConsuming(ch *amqp.Channel, consumer *managedConsumer) error {
+	args := consumer.ConsumerParams.Args
+	if consumer.Stream != nil {
+		streamArgs, err := c.streamArgs(consumer)
 		if err != nil {
 			return err
 		}
+		args = streamArgs
+	}
 
-		c.wg.Add(1)
-		go func() {
-			defer c.wg.Done()
+	prefetch := c.cfg.ConsumerQos
 
+	// prefetch (when not global) applies to consumers started afterward, so it can be set per consumer
+	// even on the shared channel, dedicated channels have their Qos set on opening
+	perConsumerQos := !consumer.DedicatedChannel && consumer.perConsumerPrefetch(c.cfg) > 0
+	if perConsumerQos {
+		prefetch = consumer.perConsumerPrefetch(c.cfg)
+		if err := ch.Qos(prefetch, 0, false); err != nil {
+			return err
+		}
+	}
+	if consumer.DedicatedChannel {
+		prefetch = consumer.qos(c.cfg).PrefetchCount
+	}
+	consumer.prefetch.Store(int64(prefetch))
+
+	deliveries, err := ch.Consume(
+		consumer.QueueParams.Name,
+		consumer.ConsumerParams.ConsumerID,
+		consumer.ConsumerParams.AutoAck,
+		consumer.ConsumerParams.Exclusive,
+		consumer.ConsumerParams.NoLocal,
+		consumer.ConsumerParams.Nowait,
+		args,
+	)
+	if err != nil {
+		return err
+	}
+	consumer.subscriptions.Add(1)
+
+	if perConsumerQos {
+		if err := ch.Qos(c.cfg.ConsumerQos, c.cfg.ConsumerPrefetchSize, c.cfg.ConsumerGlobal); err != nil {
+			return err
+		}
+	}
+
+	lanesBuffer := prefetch
+	if consumer.AdaptivePrefetch != nil {
+		deliveries = c.adaptPrefetch(ch, consumer, deliveries)
+		lanesBuffer = consumer.AdaptivePrefetch.Max
+	}
+
+	if consumer.IBatchConsumer != nil {
+		c.startBatching(consumer, deliveries)
+		return nil
+	}
+
+	if consumer.Stream != nil {
+		c.startStreaming(consumer, deliveries)
+		return nil
+	}
+
+	if consumer.PartitionKey != nil {
+		// lanes large enough to hold all prefetched deliveries never block each other
+		c.startLanes(consumer, deliveries, max(lanesBuffer, 1))
+		return nil
+	}
+
+	c.startWorkers(consumer, deliveries)
+	return nil
+}
+
+// startWorkers handles deliveries by Concurrency workers. Deliveries channel is closed on cancel
+// or channel failure, workers drain what was already received and exit.
+func (c *Client) startWorkers(consumer *managedConsumer, deliveries <-chan amqp.Delivery) {
+	for i := 0; i < consumer.workers(); i++ {
+		c.spawn(consumer, func() {
 			for msg := range deliveries {
-				consumer.Consume(context.Background(), msg)
+				consumer.busyWorkers.Add(1)
+				c.handle(consumer, msg)
+				consumer.busyWorkers.Add(-1)
 			}
-		}()
+		})
 	}
+}
 
-	return nil
+// openDedicatedChannel opens a channel used only by the consumer and watches it,
+// so that the consumer is restored on its own when the channel (but not the whole connection) fails
+func (c *Client) openDedicatedChannel(consumer *managedConsumer) (*amqp.Channel, error) {
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	qos := consumer.qos(c.cfg)
+	if err := ch.Qos(qos.PrefetchCount, qos.PrefetchSize, qos.Global); err != nil {
+		return nil, errors.Join(err, ch.Close())
+	}
+	consumer.setChannel(ch)
+	c.watchCancellations(ch)
+
+	go func() {
+		closeErr, ok := <-ch.NotifyClose(make(chan *amqp.Error, 1))
+		if !ok || closeErr == nil {
+			// closed on purpose
+			return
+		}
+		c.recoverDedicatedChannel(consumer, ch, closeErr)
+	}()
+
+	return ch, nil
+}
+
+func (c *Client) recoverDedicatedChannel(consumer *managedConsumer, failed *amqp.Channel, closeErr *amqp.Error) {
+	backoff := max(c.cfg.AutoRecoveryInterval, minResubscribeBackoff)
+
+	for {
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxResubscribeBackoff)
+
+		restored, err := c.restoreDedicatedChannel(consumer, failed)
+		if restored {
+			return
+		}
+
+		c.cfg.ConsumerAutoRecoveryErrCallback(consumer.AMQPConsumer, errors.Join(closeErr, err))
+	}
+}
+
+// restoreDedicatedChannel subscribes the consumer again unless it doesn't need it anymore
+func (c *Client) restoreDedicatedChannel(consumer *managedConsumer, failed *amqp.Channel) (bool, error) {
+	consumer.subscribeMx.Lock()
+	defer consumer.subscribeMx.Unlock()
+
+	// failure of the connection is handled by reconnect, which restores all consumers anyway,
+	// and the consumer may already be restored by it
+	if c.connection.IsClosed() || consumer.channel() != failed || consumer.getStatus() == ConsumerCancelled {
+		return true, nil
+	}
+	err := c.subscribe(consumer)
+	consumer.setErr(err)
+	return err == nil, err
 }
 
 func (c *Client) Publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
@@ -221,16 +464,76 @@ func (c *Client) Publish(ctx context.Context, exchange, key string, mandatory, i
 	return nil
 }
 
+// WithChannel opens a short-lived channel, passes it to fn and closes it afterwards.
+// It is meant for topology declarations and other one-off operations, a failure of which closes the channel
+// (e.g. passive declare of a missing queue) and thus must not happen on publisher or consumer channels.
+func (c *Client) WithChannel(fn func(ch *amqp.Channel) error) error {
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !ch.IsClosed() {
+			_ = ch.Close()
+		}
+	}()
+
+	return fn(ch)
+}
+
+// WithIsolatedChannel is like WithChannel, but the channel is opened on a separate short-lived connection.
+// It is meant for probes which may fail with a connection error (e.g. declaring an exchange of a type
+// unknown to the broker), so that the failure doesn't close the connection of publishers and consumers.
+func (c *Client) WithIsolatedChannel(fn func(ch *amqp.Channel) error) error {
+	conn, err := Dial(c.cfg.DialConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !conn.IsClosed() {
+			_ = conn.Close()
+		}
+	}()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	return fn(ch)
+}
+
+// Close stops all consumers, waits for in-flight handlers and closes the connection.
+// Handler contexts are cancelled after ShutdownGracePeriod, or right away if it's not set.
 func (c *Client) Close() error {
+	grace := time.AfterFunc(c.cfg.ShutdownGracePeriod, c.cancel)
+	defer func() {
+		grace.Stop()
+		c.cancel()
+	}()
+
+	return c.close()
+}
+
+func (c *Client) close() error {
 	var err error
 	if !c.connection.IsClosed() {
 		err = c.publisherChan.Close()
 
 		for _, consumer := range c.consumers {
-			err = c.consumerChan.Cancel(consumer.ConsumerID, false)
+			if consumer.getStatus() == ConsumerPaused {
+				continue
+			}
+			if ch := c.consumerChannel(consumer); ch != nil {
+				err = ch.Cancel(consumer.ConsumerID, false)
+			}
 		}
 		c.wg.Wait()
 
+		for _, consumer := range c.consumers {
+			if ch := consumer.channel(); ch != nil && !ch.IsClosed() {
+				err = ch.Close()
+			}
+		}
 		err = c.consumerChan.Close()
 
 		err = c.connection.Close()
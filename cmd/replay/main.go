@@ -0,0 +1,175 @@
+// Command replay moves messages from a dead-letter (or any other) queue back to their original destination.
+//
+// Example, replaying rejected "order.created" messages not older than a day at 50 messages per second:
+//
+//	replay -queue orders.prod.primary.dlq -routing-key order.created -reason rejected -max-age 24h -rate 50
+//
+// Run it with -dry-run first to see which messages would be replayed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+	"github.com/alifcapital/rabbitmq/mqutils/replay"
+)
+
+// multiFlag collects values of a repeated flag
+type multiFlag []string
+
+func (f *multiFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *multiFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var (
+		host     = flag.String("host", "localhost", "broker host")
+		port     = flag.String("port", "5672", "broker port")
+		user     = flag.String("user", "guest", "broker user")
+		password = flag.String("password", os.Getenv("RABBITMQ_PASSWORD"), "broker password, RABBITMQ_PASSWORD by default")
+
+		queue      = flag.String("queue", "", "queue to replay messages from (required)")
+		exchange   = flag.String("to-exchange", "", "publish to this exchange instead of the original one")
+		routingKey = flag.String("to-routing-key", "", "publish with this routing key instead of the original one")
+		override   = flag.Bool("override", false, "replace both exchange and routing key, even if their flags are not passed")
+
+		routingKeys multiFlag
+		headers     multiFlag
+		reasons     multiFlag
+		jsonPaths   multiFlag
+		minAge      = flag.Duration("min-age", 0, "replay messages at least this old")
+		maxAge      = flag.Duration("max-age", 0, "replay messages at most this old")
+
+		limit  = flag.Int("limit", 0, "maximum number of messages to replay")
+		rate   = flag.Float64("rate", 0, "maximum number of messages published per second")
+		dryRun = flag.Bool("dry-run", false, "only print messages which would be replayed")
+	)
+	flag.Var(&routingKeys, "routing-key", "replay messages with the original routing key, repeatable")
+	flag.Var(&headers, "header", "replay messages with the header, as name=value, repeatable")
+	flag.Var(&reasons, "reason", "replay messages dead-lettered for the reason (rejected, expired, maxlen, delivery_limit), repeatable")
+	flag.Var(&jsonPaths, "json-path", "replay messages with the value in json body, as $.path.to[0].field=value, repeatable")
+	flag.Parse()
+
+	if *queue == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := replay.Config{
+		Source: *queue,
+		Limit:  *limit,
+		Rate:   *rate,
+		DryRun: *dryRun,
+		OnMessage: func(msg amqp.Delivery, result replay.Result) {
+			if !result.Matched {
+				return
+			}
+			status := "replayed"
+			switch {
+			case *dryRun:
+				status = "would replay"
+			case result.Err != nil:
+				status = "failed: " + result.Err.Error()
+			}
+			fmt.Printf("%s %s -> exchange=%q routing_key=%q\n", msg.MessageId, status, result.Exchange, result.RoutingKey)
+		},
+	}
+
+	// only what was passed replaces the original destination, an empty -to-exchange means the default exchange
+	destination := replay.Destination{}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "to-exchange":
+			destination.Exchange = exchange
+		case "to-routing-key":
+			destination.RoutingKey = routingKey
+		}
+	})
+	if *override {
+		destination = replay.Destination{Exchange: exchange, RoutingKey: routingKey}
+	}
+	if destination.Exchange != nil || destination.RoutingKey != nil {
+		cfg.Destination = &destination
+	}
+	if len(routingKeys) > 0 {
+		cfg.Filters = append(cfg.Filters, replay.RoutingKey(routingKeys...))
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			log.Fatalf("invalid header filter %q, expected name=value", header)
+		}
+		cfg.Filters = append(cfg.Filters, replay.Header(name, value))
+	}
+	if len(reasons) > 0 {
+		cfg.Filters = append(cfg.Filters, replay.DeathReason(reasons...))
+	}
+	if *minAge > 0 || *maxAge > 0 {
+		cfg.Filters = append(cfg.Filters, replay.Age(*minAge, *maxAge))
+	}
+	for _, jsonPath := range jsonPaths {
+		path, value, ok := strings.Cut(jsonPath, "=")
+		if !ok {
+			log.Fatalf("invalid json path filter %q, expected path=value", jsonPath)
+		}
+		filter, err := replay.JSONPath(path, value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Filters = append(cfg.Filters, filter)
+	}
+
+	// connection failures stop the replay, the tool must not reconnect and keep running on its own
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	client, err := rabbitmq.NewClient(rabbitmq.ClientConfig{
+		DialConfig: rabbitmq.DialConfig{
+			User:       *user,
+			Password:   *password,
+			Host:       *host,
+			Port:       *port,
+			AMQPConfig: amqp.Config{Heartbeat: 10 * time.Second},
+		},
+		PublisherConfirmEnabled: true,
+		NetworkErrCallback: func(err *amqp.Error) {
+			log.Println("connection lost:", err)
+			cancel()
+		},
+		AutoRecoveryErrCallback: func(error) bool { return false },
+		ConsumerAutoRecoveryErrCallback: func(_ rabbitmq.AMQPConsumer, err error) {
+			log.Println("consumer lost:", err)
+			cancel()
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := replay.Run(ctx, client, cfg)
+	stop()
+	cancel()
+	_ = client.Close()
+
+	fmt.Printf("read: %d, matched: %d, replayed: %d, failed: %d\n",
+		report.Read, report.Matched, report.Replayed, report.Failed)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
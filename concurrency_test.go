@@ -0,0 +1,81 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// newTestClient returns a client without connection, whose handler contexts can be derived
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	client := &Client{}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	t.Cleanup(client.cancel)
+	return client
+}
+
+func TestWorkersDrainDeliveries(t *testing.T) {
+	client := newTestClient(t)
+
+	var running, maxRunning atomic.Int64
+	var handled []uint64
+	var mx sync.Mutex
+	release := make(chan struct{})
+
+	consumer := newManagedConsumer(AMQPConsumer{
+		ConsumerParams: ConsumerParams{Concurrency: 3},
+		IConsumer: ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				current := maxRunning.Load()
+				if n <= current || maxRunning.CompareAndSwap(current, n) {
+					break
+				}
+			}
+			<-release
+
+			mx.Lock()
+			defer mx.Unlock()
+			handled = append(handled, msg.DeliveryTag)
+		}),
+	})
+
+	deliveries := make(chan amqp.Delivery, 6)
+	for tag := uint64(1); tag <= 6; tag++ {
+		deliveries <- amqp.Delivery{DeliveryTag: tag}
+	}
+	client.startWorkers(consumer, deliveries)
+
+	deadline := time.Now().Add(time.Second)
+	for consumer.busyWorkers.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if busy := consumer.busyWorkers.Load(); busy != 3 {
+		t.Fatalf("%d busy workers, want all 3", busy)
+	}
+
+	// cancelled subscription closes deliveries, workers handle what was already received
+	close(deliveries)
+	close(release)
+	consumer.wg.Wait()
+
+	if len(handled) != 6 {
+		t.Errorf("handled deliveries %v, want all 6", handled)
+	}
+	if n := maxRunning.Load(); n > 3 {
+		t.Errorf("%d deliveries handled at once, want at most Concurrency", n)
+	}
+	if n := consumer.deliveries.Load(); n != 6 {
+		t.Errorf("%d deliveries counted, want 6", n)
+	}
+	if busy := consumer.busyWorkers.Load(); busy != 0 {
+		t.Errorf("%d workers still busy", busy)
+	}
+}
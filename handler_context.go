@@ -0,0 +1,68 @@
+package rabbitmq
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MessageDeadline returns the moment the message expires, i.e. its Timestamp plus Expiration (TTL in milliseconds).
+// It's false when publisher didn't set either of them.
+func MessageDeadline(msg amqp.Delivery) (time.Time, bool) {
+	if msg.Timestamp.IsZero() || msg.Expiration == "" {
+		return time.Time{}, false
+	}
+	ttl, err := strconv.ParseInt(msg.Expiration, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return msg.Timestamp.Add(time.Duration(ttl) * time.Millisecond), true
+}
+
+// handlerContext derives context of a single handler call from the client's base context,
+// which is cancelled on Close, and limits it by HandlerTimeout and message expiration.
+// A handler can tell that a message is already stale by checking ctx.Err() right away.
+func (c *Client) handlerContext(consumer *managedConsumer, msg amqp.Delivery) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	if consumer.HandlerTimeout > 0 {
+		ctx, cancel = withCancelChain(ctx, cancel, func(parent context.Context) (context.Context, context.CancelFunc) {
+			return context.WithTimeout(parent, consumer.HandlerTimeout)
+		})
+	}
+	if consumer.ExpirationDeadline {
+		if deadline, ok := MessageDeadline(msg); ok {
+			ctx, cancel = withCancelChain(ctx, cancel, func(parent context.Context) (context.Context, context.CancelFunc) {
+				return context.WithDeadline(parent, deadline)
+			})
+		}
+	}
+
+	return ctx, cancel
+}
+
+func withCancelChain(
+	parent context.Context,
+	parentCancel context.CancelFunc,
+	derive func(context.Context) (context.Context, context.CancelFunc),
+) (context.Context, context.CancelFunc) {
+	ctx, cancel := derive(parent)
+	return ctx, func() {
+		cancel()
+		parentCancel()
+	}
+}
+
+// handle calls the consumer within a handler context
+func (c *Client) handle(consumer *managedConsumer, msg amqp.Delivery) {
+	ctx, cancel := c.handlerContext(consumer, msg)
+	defer cancel()
+
+	consumer.deliveries.Add(1)
+
+	start := time.Now()
+	consumer.Consume(ctx, msg)
+	consumer.latency.record(time.Since(start), 1)
+}
@@ -0,0 +1,68 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestMessageDeadline(t *testing.T) {
+	published := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	deadline, ok := MessageDeadline(amqp.Delivery{Timestamp: published, Expiration: "1500"})
+	if !ok || !deadline.Equal(published.Add(1500*time.Millisecond)) {
+		t.Errorf("MessageDeadline() = %s, %t", deadline, ok)
+	}
+
+	for name, msg := range map[string]amqp.Delivery{
+		"no timestamp":       {Expiration: "1500"},
+		"no expiration":      {Timestamp: published},
+		"malformed duration": {Timestamp: published, Expiration: "soon"},
+	} {
+		if _, ok := MessageDeadline(msg); ok {
+			t.Errorf("%s: MessageDeadline() is set", name)
+		}
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	client := newTestClient(t)
+
+	consumer := newManagedConsumer(AMQPConsumer{ConsumerParams: ConsumerParams{
+		HandlerTimeout:     time.Hour,
+		ExpirationDeadline: true,
+	}})
+
+	// the earliest of the timeout and message expiration wins
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	ctx, cancel := client.handlerContext(consumer, amqp.Delivery{Timestamp: expiresAt.Add(-time.Second), Expiration: "1000"})
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(expiresAt) {
+		t.Errorf("deadline of expiring message %s, want %s", deadline, expiresAt)
+	}
+	cancel()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("context error %v after cancel, want context.Canceled", ctx.Err())
+	}
+
+	ctx, cancel = client.handlerContext(consumer, amqp.Delivery{})
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Hour {
+		t.Errorf("deadline of message without expiration %s, want HandlerTimeout", deadline)
+	}
+
+	// an already expired message is seen right away
+	stale, cancelStale := client.handlerContext(consumer, amqp.Delivery{Timestamp: time.Now().Add(-time.Minute), Expiration: "1000"})
+	defer cancelStale()
+	if !errors.Is(stale.Err(), context.DeadlineExceeded) {
+		t.Errorf("context error of expired message %v, want context.DeadlineExceeded", stale.Err())
+	}
+
+	// closing the client cancels handlers in flight
+	client.cancel()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("context error %v after close, want context.Canceled", ctx.Err())
+	}
+}
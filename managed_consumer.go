@@ -0,0 +1,355 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrConsumerNotFound = errors.New("consumer not found")
+
+type ConsumerStatus string
+
+const (
+	ConsumerActive ConsumerStatus = "active"
+	ConsumerPaused ConsumerStatus = "paused"
+	// subscribing (or restoring after recovery) failed, see ConsumerInfo.LastError
+	ConsumerFailed ConsumerStatus = "failed"
+	// consumer was removed by Client.Cancel
+	ConsumerCancelled ConsumerStatus = "cancelled"
+)
+
+// ConsumerStats is a point-in-time snapshot of consumer runtime metrics,
+// meant to be exported into prometheus, statsd ...etc. by the application
+type ConsumerStats struct {
+	ConsumerID string
+	Queue      string
+	// number of goroutines handling deliveries
+	Workers int
+	// number of workers handling a delivery at the moment
+	BusyWorkers int
+	// total number of deliveries handled
+	Deliveries uint64
+	// prefetch the consumer was subscribed with, or the current one of adaptive prefetch
+	Prefetch int
+}
+
+// ConsumerInfo describes a registered consumer
+type ConsumerInfo struct {
+	ConsumerStats
+	Status ConsumerStatus
+	// the last error of subscribing, restoring or settling a batch of the consumer
+	LastError error
+}
+
+// managedConsumer keeps runtime state of a consumer registered via Client.Consume
+type managedConsumer struct {
+	AMQPConsumer
+
+	busyWorkers atomic.Int64
+	deliveries  atomic.Uint64
+	prefetch    atomic.Int64
+	// handler latency for adaptive prefetch
+	latency latencyMeter
+
+	// dedicated channel, if any
+	ch      *amqp.Channel
+	status  ConsumerStatus
+	lastErr error
+	mx      sync.Mutex
+
+	// number of successful subscriptions (basic.consume), it tells resubscribing after cancellation by broker
+	// whether the consumer was subscribed again meanwhile, e.g. by Resume
+	subscriptions atomic.Uint64
+	// serialises subscribing, so that reconnect, recovery of the dedicated channel, resubscribing after
+	// cancellation by broker, Pause and Resume don't interleave and don't restore the consumer twice
+	subscribeMx sync.Mutex
+
+	// workers of the consumer, it lets wait for draining of a single consumer
+	wg sync.WaitGroup
+
+	// offsets of a stream consumer
+	stream *streamState
+}
+
+func newManagedConsumer(consumer AMQPConsumer) *managedConsumer {
+	managed := &managedConsumer{AMQPConsumer: consumer, status: ConsumerActive}
+	if consumer.Stream != nil {
+		managed.stream = newStreamState()
+	}
+	return managed
+}
+
+func (m *managedConsumer) workers() int {
+	return max(m.Concurrency, 1)
+}
+
+func (m *managedConsumer) channel() *amqp.Channel {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	return m.ch
+}
+
+func (m *managedConsumer) setChannel(ch *amqp.Channel) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.ch = ch
+}
+
+// recordErr keeps error for ConsumerInfo without changing status
+func (m *managedConsumer) recordErr(err error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.lastErr = err
+}
+
+// consumes tells whether the consumer receives deliveries or only declares topology
+func (m *managedConsumer) consumes() bool {
+	return m.IConsumer != nil || m.IBatchConsumer != nil
+}
+
+func (m *managedConsumer) getStatus() ConsumerStatus {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	return m.status
+}
+
+func (m *managedConsumer) setStatus(status ConsumerStatus) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.status = status
+}
+
+// setErr records result of subscribing, paused and cancelled consumers keep their status
+func (m *managedConsumer) setErr(err error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if err != nil {
+		m.lastErr = err
+	}
+	if m.status == ConsumerActive || m.status == ConsumerFailed {
+		if err != nil {
+			m.status = ConsumerFailed
+		} else {
+			m.status = ConsumerActive
+		}
+	}
+}
+
+// qos returns Qos of the dedicated channel
+func (m *managedConsumer) qos(cfg ClientConfig) QosParams {
+	qos := m.QosParams
+	if qos.PrefetchCount == 0 {
+		qos.PrefetchCount = cfg.ConsumerQos
+	}
+	if qos.PrefetchCount == 0 && m.Concurrency > 1 {
+		qos.PrefetchCount = m.Concurrency
+	}
+	if qos.PrefetchCount == 0 && m.IBatchConsumer != nil {
+		qos.PrefetchCount = m.BatchSize
+	}
+	if qos.PrefetchSize == 0 {
+		qos.PrefetchSize = cfg.ConsumerPrefetchSize
+	}
+	if params := m.AdaptivePrefetch; params != nil {
+		// prefetch adjusted before the channel was reopened is kept
+		if current := int(m.prefetch.Load()); current > 0 {
+			qos.PrefetchCount = current
+		}
+		qos.PrefetchCount = min(max(qos.PrefetchCount, params.Min), params.Max)
+		qos.Global = true
+	}
+	return qos
+}
+
+// perConsumerPrefetch returns prefetch of the consumer on the shared channel, zero means channel's one is used
+func (m *managedConsumer) perConsumerPrefetch(cfg ClientConfig) int {
+	if m.PrefetchCount > 0 {
+		return m.PrefetchCount
+	}
+	if cfg.ConsumerQos == 0 && m.Concurrency > 1 {
+		return m.Concurrency
+	}
+	return 0
+}
+
+func (m *managedConsumer) stats() ConsumerStats {
+	return ConsumerStats{
+		ConsumerID:  m.ConsumerID,
+		Queue:       m.QueueParams.Name,
+		Workers:     m.workers(),
+		BusyWorkers: int(m.busyWorkers.Load()),
+		Deliveries:  m.deliveries.Load(),
+		Prefetch:    int(m.prefetch.Load()),
+	}
+}
+
+func (m *managedConsumer) info() ConsumerInfo {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	return ConsumerInfo{
+		ConsumerStats: m.stats(),
+		Status:        m.status,
+		LastError:     m.lastErr,
+	}
+}
+
+// Stats returns metrics of all registered consumers
+func (c *Client) Stats() []ConsumerStats {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	stats := make([]ConsumerStats, 0, len(c.consumers))
+	for _, consumer := range c.consumers {
+		stats = append(stats, consumer.stats())
+	}
+	return stats
+}
+
+// Consumers returns status of all registered consumers
+func (c *Client) Consumers() []ConsumerInfo {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	infos := make([]ConsumerInfo, 0, len(c.consumers))
+	for _, consumer := range c.consumers {
+		infos = append(infos, consumer.info())
+	}
+	return infos
+}
+
+// Cancel stops the consumer, waits until its in-flight deliveries are handled and forgets it,
+// so that it's not restored on reconnect. Declared topology is left untouched.
+func (c *Client) Cancel(consumerID string) error {
+	c.mx.Lock()
+	var consumer *managedConsumer
+	for i, registered := range c.consumers {
+		if registered.ConsumerID == consumerID {
+			consumer = registered
+			c.consumers = append(c.consumers[:i:i], c.consumers[i+1:]...)
+			break
+		}
+	}
+	c.mx.Unlock()
+
+	if consumer == nil {
+		return fmt.Errorf("%w: %s", ErrConsumerNotFound, consumerID)
+	}
+
+	consumer.subscribeMx.Lock()
+	defer consumer.subscribeMx.Unlock()
+
+	wasPaused := consumer.getStatus() == ConsumerPaused
+	consumer.setStatus(ConsumerCancelled)
+
+	var err error
+	if !wasPaused {
+		err = c.stopConsuming(consumer)
+	}
+	if ch := consumer.channel(); ch != nil && !ch.IsClosed() {
+		err = errors.Join(err, ch.Close())
+	}
+	return err
+}
+
+// Pause stops receiving deliveries (basic.cancel) and waits until in-flight ones are handled.
+// Topology and dedicated channel are kept, and paused consumer stays paused after reconnect until resumed.
+func (c *Client) Pause(consumerID string) error {
+	consumer, err := c.findConsumer(consumerID)
+	if err != nil {
+		return err
+	}
+
+	consumer.subscribeMx.Lock()
+	defer consumer.subscribeMx.Unlock()
+
+	consumer.mx.Lock()
+	if consumer.status == ConsumerPaused {
+		consumer.mx.Unlock()
+		return nil
+	}
+	consumer.status = ConsumerPaused
+	consumer.mx.Unlock()
+
+	return c.stopConsuming(consumer)
+}
+
+// Resume starts receiving deliveries of a paused consumer again
+func (c *Client) Resume(consumerID string) error {
+	consumer, err := c.findConsumer(consumerID)
+	if err != nil {
+		return err
+	}
+
+	consumer.subscribeMx.Lock()
+	defer consumer.subscribeMx.Unlock()
+
+	consumer.mx.Lock()
+	if consumer.status != ConsumerPaused {
+		consumer.mx.Unlock()
+		return nil
+	}
+	consumer.status = ConsumerActive
+	consumer.mx.Unlock()
+
+	ch := c.consumerChannel(consumer)
+	if ch == nil || ch.IsClosed() {
+		// the channel is being recovered, which starts consuming anyway
+		return nil
+	}
+	err = c.startConsuming(ch, consumer)
+	consumer.setErr(err)
+	return err
+}
+
+func (c *Client) findConsumer(consumerID string) (*managedConsumer, error) {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	for _, consumer := range c.consumers {
+		if consumer.ConsumerID == consumerID {
+			return consumer, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrConsumerNotFound, consumerID)
+}
+
+// consumerChannel returns the channel the consumer receives deliveries from
+func (c *Client) consumerChannel(consumer *managedConsumer) *amqp.Channel {
+	if consumer.DedicatedChannel {
+		return consumer.channel()
+	}
+	return c.consumerChan
+}
+
+// stopConsuming cancels subscription of the consumer and waits for its workers
+func (c *Client) stopConsuming(consumer *managedConsumer) error {
+	var err error
+	if ch := c.consumerChannel(consumer); ch != nil && !ch.IsClosed() {
+		err = ch.Cancel(consumer.ConsumerID, false)
+	}
+	consumer.wg.Wait()
+	return err
+}
+
+// spawn runs a worker of the consumer, which is awaited on Close, Pause and Cancel
+func (c *Client) spawn(consumer *managedConsumer, fn func()) {
+	c.wg.Add(1)
+	consumer.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer consumer.wg.Done()
+
+		fn()
+	}()
+}
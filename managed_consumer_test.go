@@ -0,0 +1,130 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestConsumer registers a consumer without subscribing it, the client has no connection
+func newTestConsumer(client *Client, consumerID string) *managedConsumer {
+	consumer := newManagedConsumer(AMQPConsumer{ConsumerParams: ConsumerParams{ConsumerID: consumerID}})
+	client.consumers = append(client.consumers, consumer)
+	return consumer
+}
+
+func TestPauseWaitsForInFlightDeliveries(t *testing.T) {
+	client := &Client{}
+	consumer := newTestConsumer(client, "c1")
+
+	release := make(chan struct{})
+	client.spawn(consumer, func() { <-release })
+
+	paused := make(chan error, 1)
+	go func() { paused <- client.Pause("c1") }()
+
+	select {
+	case <-paused:
+		t.Fatal("Pause returned while a delivery is in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-paused; err != nil {
+		t.Fatal(err)
+	}
+	if status := consumer.getStatus(); status != ConsumerPaused {
+		t.Errorf("status %s, want paused", status)
+	}
+}
+
+func TestPauseResumeCancel(t *testing.T) {
+	client := &Client{}
+	consumer := newTestConsumer(client, "c1")
+
+	// resuming an active consumer and pausing a paused one change nothing
+	if err := client.Resume("c1"); err != nil || consumer.getStatus() != ConsumerActive {
+		t.Errorf("Resume() of active consumer = %v, status %s", err, consumer.getStatus())
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.Pause("c1"); err != nil || consumer.getStatus() != ConsumerPaused {
+			t.Errorf("Pause() = %v, status %s, want paused", err, consumer.getStatus())
+		}
+	}
+
+	// the channel is gone, so the consumer starts consuming once the channel is restored
+	if err := client.Resume("c1"); err != nil || consumer.getStatus() != ConsumerActive {
+		t.Errorf("Resume() = %v, status %s, want active", err, consumer.getStatus())
+	}
+
+	if err := client.Cancel("c1"); err != nil {
+		t.Fatal(err)
+	}
+	if status := consumer.getStatus(); status != ConsumerCancelled {
+		t.Errorf("status %s, want cancelled", status)
+	}
+	if len(client.Consumers()) != 0 {
+		t.Error("cancelled consumer is still registered")
+	}
+	for name, fn := range map[string]func(string) error{"Pause": client.Pause, "Resume": client.Resume, "Cancel": client.Cancel} {
+		if err := fn("c1"); !errors.Is(err, ErrConsumerNotFound) {
+			t.Errorf("%s() of cancelled consumer = %v, want ErrConsumerNotFound", name, err)
+		}
+	}
+}
+
+func TestConsumerQos(t *testing.T) {
+	cfg := ClientConfig{ConsumerQos: 10, ConsumerPrefetchSize: 1024}
+
+	tests := []struct {
+		name     string
+		consumer AMQPConsumer
+		cfg      ClientConfig
+		want     QosParams
+		// prefetch set by basic.qos before basic.consume on the shared channel
+		wantShared int
+	}{
+		{
+			name:     "client defaults",
+			consumer: AMQPConsumer{},
+			cfg:      cfg,
+			want:     QosParams{PrefetchCount: 10, PrefetchSize: 1024},
+		},
+		{
+			name:       "own prefetch",
+			consumer:   AMQPConsumer{QosParams: QosParams{PrefetchCount: 3, Global: true}},
+			cfg:        cfg,
+			want:       QosParams{PrefetchCount: 3, PrefetchSize: 1024, Global: true},
+			wantShared: 3,
+		},
+		{
+			name:       "concurrency without client prefetch",
+			consumer:   AMQPConsumer{ConsumerParams: ConsumerParams{Concurrency: 4}},
+			want:       QosParams{PrefetchCount: 4},
+			wantShared: 4,
+		},
+		{
+			name:     "concurrency within client prefetch",
+			consumer: AMQPConsumer{ConsumerParams: ConsumerParams{Concurrency: 4}},
+			cfg:      cfg,
+			want:     QosParams{PrefetchCount: 10, PrefetchSize: 1024},
+		},
+		{
+			name: "adaptive prefetch within bounds",
+			consumer: AMQPConsumer{ConsumerParams: ConsumerParams{
+				AdaptivePrefetch: &AdaptivePrefetchParams{Min: 20, Max: 50},
+			}},
+			cfg:  cfg,
+			want: QosParams{PrefetchCount: 20, PrefetchSize: 1024, Global: true},
+		},
+	}
+	for _, tt := range tests {
+		consumer := newManagedConsumer(tt.consumer)
+		if got := consumer.qos(tt.cfg); got != tt.want {
+			t.Errorf("%s: qos() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if got := consumer.perConsumerPrefetch(tt.cfg); got != tt.wantShared {
+			t.Errorf("%s: perConsumerPrefetch() = %d, want %d", tt.name, got, tt.wantShared)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mqutils
+
+import amqp "github.com/rabbitmq/amqp091-go"
+
+// hookAcknowledger lets middlewares find out how a delivery was settled by underlying consumers,
+// hooks are invoked only when acknowledgement succeeded
+type hookAcknowledger struct {
+	amqp.Acknowledger
+	afterAck  func()
+	afterNack func(requeue bool)
+}
+
+// withAckHooks replaces Acknowledger of the delivery, so that hooks are called after Ack/Nack/Reject
+func withAckHooks(msg amqp.Delivery, afterAck func(), afterNack func(requeue bool)) amqp.Delivery {
+	if msg.Acknowledger == nil {
+		// such delivery can't be settled anyway
+		return msg
+	}
+	msg.Acknowledger = &hookAcknowledger{
+		Acknowledger: msg.Acknowledger,
+		afterAck:     afterAck,
+		afterNack:    afterNack,
+	}
+	return msg
+}
+
+func (a *hookAcknowledger) Ack(tag uint64, multiple bool) error {
+	if err := a.Acknowledger.Ack(tag, multiple); err != nil {
+		return err
+	}
+	if a.afterAck != nil {
+		a.afterAck()
+	}
+	return nil
+}
+
+func (a *hookAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	if err := a.Acknowledger.Nack(tag, multiple, requeue); err != nil {
+		return err
+	}
+	if a.afterNack != nil {
+		a.afterNack(requeue)
+	}
+	return nil
+}
+
+func (a *hookAcknowledger) Reject(tag uint64, requeue bool) error {
+	if err := a.Acknowledger.Reject(tag, requeue); err != nil {
+		return err
+	}
+	if a.afterNack != nil {
+		a.afterNack(requeue)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package mqutils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// causation id is the id of a message which caused publishing of the current one
+const causationIDHeader = "x-causation-id"
+
+// MessageBuilder builds amqp.Publishing step by step, collecting errors until Build is called.
+//
+//	msg, err := mqutils.NewMessageBuilder(body).
+//		WithMessageIDv7().
+//		WithType("order.created").
+//		WithHeader("tags", []string{"a", "b"}).
+//		Build()
+type MessageBuilder struct {
+	msg     amqp.Publishing
+	headers map[string]any
+	errs    []error
+}
+
+// NewMessageBuilder starts with a persistent json message timestamped now, the same way as NewMessage does
+func NewMessageBuilder(body []byte) *MessageBuilder {
+	return &MessageBuilder{
+		msg: amqp.Publishing{
+			ContentType:     "text/json",
+			ContentEncoding: "utf-8",
+			Timestamp:       time.Now(),
+			DeliveryMode:    amqp.Persistent,
+			Body:            body,
+		},
+		headers: make(map[string]any),
+	}
+}
+
+func (b *MessageBuilder) WithMessageID(id string) *MessageBuilder {
+	b.msg.MessageId = id
+	return b
+}
+
+// WithMessageIDv4 sets random UUID as message id
+func (b *MessageBuilder) WithMessageIDv4() *MessageBuilder {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		b.errs = append(b.errs, fmt.Errorf("generating message id: %w", err))
+		return b
+	}
+	b.msg.MessageId = id.String()
+	return b
+}
+
+// WithMessageIDv7 sets time-ordered UUID as message id, which is friendlier to database indexes
+func (b *MessageBuilder) WithMessageIDv7() *MessageBuilder {
+	id, err := uuid.NewV7()
+	if err != nil {
+		b.errs = append(b.errs, fmt.Errorf("generating message id: %w", err))
+		return b
+	}
+	b.msg.MessageId = id.String()
+	return b
+}
+
+func (b *MessageBuilder) WithCorrelationID(id string) *MessageBuilder {
+	b.msg.CorrelationId = id
+	return b
+}
+
+// WithCausationID sets id of the message which caused this one, it is sent in `x-causation-id` header
+func (b *MessageBuilder) WithCausationID(id string) *MessageBuilder {
+	b.headers[causationIDHeader] = id
+	return b
+}
+
+// WithCause sets correlation id and causation id from the message being handled,
+// correlation id is inherited, so that all messages of the same flow share it
+func (b *MessageBuilder) WithCause(msg amqp.Delivery) *MessageBuilder {
+	correlationID := msg.CorrelationId
+	if correlationID == "" {
+		correlationID = msg.MessageId
+	}
+	return b.WithCorrelationID(correlationID).WithCausationID(msg.MessageId)
+}
+
+func (b *MessageBuilder) WithType(messageType string) *MessageBuilder {
+	b.msg.Type = messageType
+	return b
+}
+
+func (b *MessageBuilder) WithAppID(appID string) *MessageBuilder {
+	b.msg.AppId = appID
+	return b
+}
+
+func (b *MessageBuilder) WithContentType(contentType, contentEncoding string) *MessageBuilder {
+	b.msg.ContentType = contentType
+	b.msg.ContentEncoding = contentEncoding
+	return b
+}
+
+func (b *MessageBuilder) WithTimestamp(timestamp time.Time) *MessageBuilder {
+	b.msg.Timestamp = timestamp
+	return b
+}
+
+// WithTTL sets per-message TTL, broker requires it in milliseconds (`Expiration` property)
+func (b *MessageBuilder) WithTTL(ttl time.Duration) *MessageBuilder {
+	if ttl < time.Millisecond {
+		b.errs = append(b.errs, fmt.Errorf("ttl must be at least 1ms, got %s", ttl))
+		return b
+	}
+	b.msg.Expiration = strconv.FormatInt(ttl.Milliseconds(), 10)
+	return b
+}
+
+// WithPriority is effective only for queues declared with `x-max-priority`, which is at most 255
+func (b *MessageBuilder) WithPriority(priority uint8) *MessageBuilder {
+	b.msg.Priority = priority
+	return b
+}
+
+func (b *MessageBuilder) WithReplyTo(replyTo string) *MessageBuilder {
+	b.msg.ReplyTo = replyTo
+	return b
+}
+
+func (b *MessageBuilder) WithPersistence(persistent bool) *MessageBuilder {
+	if persistent {
+		b.msg.DeliveryMode = amqp.Persistent
+	} else {
+		b.msg.DeliveryMode = amqp.Transient
+	}
+	return b
+}
+
+// WithHeader sets a header, value is converted into a type amqp.Table accepts when Build is called
+func (b *MessageBuilder) WithHeader(key string, value any) *MessageBuilder {
+	b.headers[key] = value
+	return b
+}
+
+// WithHeaders sets several headers at once, see WithHeader
+func (b *MessageBuilder) WithHeaders(headers map[string]any) *MessageBuilder {
+	for k, v := range headers {
+		b.headers[k] = v
+	}
+	return b
+}
+
+// Build validates the message and returns it, Headers are never nil
+func (b *MessageBuilder) Build() (amqp.Publishing, error) {
+	errs := append([]error{}, b.errs...)
+
+	headers := amqp.Table{}
+	for k, v := range b.headers {
+		value, err := toHeaderValue(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("header %q: %w", k, err))
+			continue
+		}
+		headers[k] = value
+	}
+	if len(errs) == 0 {
+		if err := headers.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return amqp.Publishing{}, errors.Join(errs...)
+	}
+
+	msg := b.msg
+	msg.Headers = headers
+	return msg, nil
+}
+
+// toHeaderValue converts v into a type accepted by amqp.Table:
+// maps with string keys become tables, slices and arrays (except []byte) become []interface{},
+// unsigned and named numeric types become their signed counterparts and time.Time is truncated to seconds in UTC
+func toHeaderValue(v any) (any, error) {
+	switch value := v.(type) {
+	case nil, bool, byte, int8, int, int16, int32, int64, float32, float64, string, []byte, amqp.Decimal:
+		return value, nil
+	case time.Time:
+		// amqp timestamps have seconds precision
+		return value.UTC().Truncate(time.Second), nil
+	case amqp.Table:
+		return toHeaderTable(reflect.ValueOf(value))
+	case []interface{}:
+		return toHeaderArray(reflect.ValueOf(value))
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint8, reflect.Uint16:
+		return int32(rv.Uint()), nil
+	case reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("value %d overflows int64", rv.Uint())
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings, got %s", rv.Type().Key())
+		}
+		return toHeaderTable(rv)
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			bytes := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(bytes), rv)
+			return bytes, nil
+		}
+		return toHeaderArray(rv)
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return toHeaderValue(rv.Elem().Interface())
+	}
+
+	return nil, fmt.Errorf("value of type %T is not supported", v)
+}
+
+func toHeaderTable(rv reflect.Value) (amqp.Table, error) {
+	table := amqp.Table{}
+	iter := rv.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		value, err := toHeaderValue(iter.Value().Interface())
+		if err != nil {
+			return nil, fmt.Errorf("table field %q: %w", key, err)
+		}
+		table[key] = value
+	}
+	return table, nil
+}
+
+func toHeaderArray(rv reflect.Value) ([]interface{}, error) {
+	array := make([]interface{}, rv.Len())
+	for i := range array {
+		value, err := toHeaderValue(rv.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("array item %d: %w", i, err)
+		}
+		array[i] = value
+	}
+	return array, nil
+}
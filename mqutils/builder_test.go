@@ -0,0 +1,68 @@
+package mqutils
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type testStatus string
+
+func TestToHeaderValue(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 15, 999, time.FixedZone("UTC+5", 5*3600))
+	answer := 42
+
+	tests := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{name: "nil", value: nil, want: nil},
+		{name: "string", value: "text", want: "text"},
+		{name: "int", value: 7, want: 7},
+		{name: "named string", value: testStatus("active"), want: "active"},
+		{name: "uint16", value: uint16(65535), want: int32(65535)},
+		{name: "uint64", value: uint64(1 << 40), want: int64(1 << 40)},
+		{name: "time", value: at, want: time.Date(2024, 5, 1, 7, 30, 15, 0, time.UTC)},
+		{name: "bytes", value: []byte("raw"), want: []byte("raw")},
+		{name: "byte array", value: [3]byte{'r', 'a', 'w'}, want: []byte("raw")},
+		{name: "pointer", value: &answer, want: 42},
+		{name: "nil pointer", value: (*int)(nil), want: nil},
+		{name: "slice", value: []uint32{1, 2}, want: []interface{}{int64(1), int64(2)}},
+		{
+			name:  "nested map",
+			value: map[string]any{"ids": []string{"a"}, "meta": map[string]uint8{"v": 1}},
+			want:  amqp.Table{"ids": []interface{}{"a"}, "meta": amqp.Table{"v": uint8(1)}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := toHeaderValue(tt.value)
+		if err != nil {
+			t.Errorf("%s: toHeaderValue() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: toHeaderValue() = %#v, want %#v", tt.name, got, tt.want)
+		}
+		if err := (amqp.Table{"value": got}).Validate(); err != nil {
+			t.Errorf("%s: converted value is not valid in a table: %v", tt.name, err)
+		}
+	}
+}
+
+func TestToHeaderValueRejectsUnsupportedValues(t *testing.T) {
+	values := map[string]any{
+		"overflowing uint64": uint64(math.MaxUint64),
+		"map with int keys":  map[int]string{1: "a"},
+		"struct":             struct{ ID int }{ID: 1},
+		"nested channel":     []any{make(chan int)},
+	}
+	for name, value := range values {
+		if got, err := toHeaderValue(value); err == nil {
+			t.Errorf("%s: toHeaderValue() = %#v, want error", name, got)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package mqutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+const (
+	// headers describing a chunk of a larger message
+	chunkTransferIDHeader = "x-chunk-transfer-id"
+	chunkIndexHeader      = "x-chunk-index"
+	chunkCountHeader      = "x-chunk-count"
+)
+
+// Chunker splits message body into ordered chunks of at most chunkSize bytes
+type Chunker struct {
+	chunkSize int
+}
+
+func NewChunker(chunkSize int) (*Chunker, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", chunkSize)
+	}
+	return &Chunker{chunkSize: chunkSize}, nil
+}
+
+// Split returns chunks sharing the same transfer id, message which fits into one chunk is returned as is
+func (c *Chunker) Split(msg amqp.Publishing) []amqp.Publishing {
+	if len(msg.Body) <= c.chunkSize {
+		return []amqp.Publishing{msg}
+	}
+
+	transferID := uuid.NewString()
+	count := (len(msg.Body) + c.chunkSize - 1) / c.chunkSize
+
+	chunks := make([]amqp.Publishing, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*c.chunkSize, len(msg.Body))
+
+		chunk := msg
+		chunk.Headers = copyHeaders(msg.Headers)
+		chunk.Headers[chunkTransferIDHeader] = transferID
+		chunk.Headers[chunkIndexHeader] = int32(i)
+		chunk.Headers[chunkCountHeader] = int32(count)
+		chunk.Body = msg.Body[i*c.chunkSize : end]
+
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Publish splits message and publishes chunks one by one the same way as Publish does
+func (c *Chunker) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing, client *rabbitmq.Client) error {
+	for _, chunk := range c.Split(msg) {
+		if err := Publish(ctx, exchange, key, chunk, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ChunkReassemblyConfig struct {
+	// incomplete transfers are rejected (without requeue) when no chunk arrived during this time
+	Timeout time.Duration
+	// upper bound of bytes buffered for all incomplete transfers, transfer which doesn't fit is rejected
+	// (without requeue), since requeued chunks would overflow the buffer again
+	MaxBytes int
+}
+
+type ChunkReassemblyErrCallback func(ctx context.Context, msg amqp.Delivery, err error)
+
+// NewChunkReassemblyMiddleware buffers chunks published by Chunker and calls next consumer once per transfer
+// with the full body. Acknowledging (or rejecting) the reassembled message settles all of its chunks.
+//
+// Chunks are kept unacknowledged while buffering, so consumer prefetch must be large enough
+// to receive all chunks of the largest transfer. Partitioned consumers must set
+// ConsumerParams.PartitionSettleTimeout, otherwise the first buffered chunk stalls its lane.
+func NewChunkReassemblyMiddleware(cfg ChunkReassemblyConfig, cb ChunkReassemblyErrCallback) Middleware {
+	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
+		r := &chunkReassembler{
+			cfg:       cfg,
+			cb:        cb,
+			transfers: make(map[string]*chunkTransfer),
+			rejected:  make(map[string]int),
+		}
+
+		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
+			transferID, ok := msg.Headers[chunkTransferIDHeader].(string)
+			if !ok {
+				next.Consume(ctx, msg)
+				return
+			}
+
+			if assembled, ok := r.add(ctx, transferID, msg); ok {
+				next.Consume(ctx, assembled)
+			}
+		})
+	}
+}
+
+type chunkReassembler struct {
+	cfg       ChunkReassemblyConfig
+	cb        ChunkReassemblyErrCallback
+	transfers map[string]*chunkTransfer
+	// chunks still expected of transfers rejected on a full buffer
+	rejected map[string]int
+	buffered int
+	mx       sync.Mutex
+}
+
+type chunkTransfer struct {
+	chunks []amqp.Delivery
+	filled []bool
+	left   int
+	size   int
+	timer  *time.Timer
+}
+
+// add buffers the chunk and returns reassembled message once all chunks are received
+func (r *chunkReassembler) add(ctx context.Context, transferID string, msg amqp.Delivery) (amqp.Delivery, bool) {
+	index, indexOk := msg.Headers[chunkIndexHeader].(int32)
+	count, countOk := msg.Headers[chunkCountHeader].(int32)
+	if !indexOk || !countOk || count <= 0 || index < 0 || index >= count {
+		r.reject(ctx, []amqp.Delivery{msg}, false, fmt.Errorf("malformed chunk of transfer: %s", transferID))
+		return amqp.Delivery{}, false
+	}
+
+	r.mx.Lock()
+	if left, ok := r.rejected[transferID]; ok {
+		if left <= 1 {
+			delete(r.rejected, transferID)
+		} else {
+			r.rejected[transferID] = left - 1
+		}
+		r.mx.Unlock()
+
+		r.reject(ctx, []amqp.Delivery{msg}, false, fmt.Errorf("chunk of rejected transfer: %s", transferID))
+		return amqp.Delivery{}, false
+	}
+
+	t, ok := r.transfers[transferID]
+	if !ok {
+		t = &chunkTransfer{
+			chunks: make([]amqp.Delivery, count),
+			filled: make([]bool, count),
+			left:   int(count),
+		}
+		r.transfers[transferID] = t
+	}
+	// the transfer is sized by its first chunk, the rest must agree with it
+	if int(count) != len(t.chunks) {
+		r.mx.Unlock()
+
+		r.reject(ctx, []amqp.Delivery{msg}, false, fmt.Errorf("malformed chunk of transfer: %s", transferID))
+		return amqp.Delivery{}, false
+	}
+
+	if r.cfg.MaxBytes > 0 && r.buffered+len(msg.Body) > r.cfg.MaxBytes {
+		r.drop(transferID, t)
+		if left := t.left - 1; left > 0 {
+			r.rejected[transferID] = left
+		}
+		r.mx.Unlock()
+
+		r.reject(ctx, append(t.received(), msg), false, fmt.Errorf("chunk buffer is full, transfer %s is rejected", transferID))
+		return amqp.Delivery{}, false
+	}
+
+	if t.filled[index] {
+		// redelivered chunk, the previous delivery can't be settled anymore
+		r.buffered -= len(t.chunks[index].Body)
+		t.size -= len(t.chunks[index].Body)
+	} else {
+		t.filled[index] = true
+		t.left--
+	}
+	t.chunks[index] = msg
+	t.size += len(msg.Body)
+	r.buffered += len(msg.Body)
+
+	if t.left > 0 {
+		if r.cfg.Timeout > 0 {
+			if t.timer != nil {
+				t.timer.Stop()
+			}
+			t.timer = time.AfterFunc(r.cfg.Timeout, func() { r.expire(ctx, transferID, t) })
+		}
+		r.mx.Unlock()
+		return amqp.Delivery{}, false
+	}
+
+	r.drop(transferID, t)
+	r.mx.Unlock()
+
+	return t.assemble(), true
+}
+
+func (r *chunkReassembler) expire(ctx context.Context, transferID string, t *chunkTransfer) {
+	r.mx.Lock()
+	if r.transfers[transferID] != t {
+		r.mx.Unlock()
+		return
+	}
+	r.drop(transferID, t)
+	r.mx.Unlock()
+
+	r.reject(ctx, t.received(), false, fmt.Errorf("transfer %s timed out with %d chunks missing", transferID, t.left))
+}
+
+// drop forgets transfer, must be called under lock
+func (r *chunkReassembler) drop(transferID string, t *chunkTransfer) {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	r.buffered -= t.size
+	delete(r.transfers, transferID)
+}
+
+func (r *chunkReassembler) reject(ctx context.Context, chunks []amqp.Delivery, requeue bool, err error) {
+	if r.cb != nil && len(chunks) > 0 {
+		r.cb(ctx, chunks[0], err)
+	}
+	for _, chunk := range chunks {
+		if err := chunk.Nack(false, requeue); err != nil && r.cb != nil {
+			r.cb(ctx, chunk, errors.Join(NewNackFailedError(chunk), err))
+		}
+	}
+}
+
+func (t *chunkTransfer) received() []amqp.Delivery {
+	var chunks []amqp.Delivery
+	for i, filled := range t.filled {
+		if filled {
+			chunks = append(chunks, t.chunks[i])
+		}
+	}
+	return chunks
+}
+
+func (t *chunkTransfer) assemble() amqp.Delivery {
+	var body bytes.Buffer
+	body.Grow(t.size)
+	for _, chunk := range t.chunks {
+		body.Write(chunk.Body)
+	}
+
+	msg := t.chunks[len(t.chunks)-1]
+	msg.Headers = copyHeaders(msg.Headers)
+	delete(msg.Headers, chunkTransferIDHeader)
+	delete(msg.Headers, chunkIndexHeader)
+	delete(msg.Headers, chunkCountHeader)
+	msg.Body = body.Bytes()
+	msg.Acknowledger = chunksAcknowledger(t.chunks)
+
+	return msg
+}
+
+// chunksAcknowledger settles all chunks of a transfer at once
+type chunksAcknowledger []amqp.Delivery
+
+func (a chunksAcknowledger) Ack(_ uint64, _ bool) error {
+	var err error
+	for _, chunk := range a {
+		err = errors.Join(err, chunk.Ack(false))
+	}
+	return err
+}
+
+func (a chunksAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	var err error
+	for _, chunk := range a {
+		err = errors.Join(err, chunk.Nack(false, requeue))
+	}
+	return err
+}
+
+func (a chunksAcknowledger) Reject(_ uint64, requeue bool) error {
+	var err error
+	for _, chunk := range a {
+		err = errors.Join(err, chunk.Reject(requeue))
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package mqutils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+// chunkDeliveries splits body into chunks the way a consumer receives them
+func chunkDeliveries(t *testing.T, chunkSize int, body string) []amqp.Delivery {
+	t.Helper()
+
+	chunker, err := NewChunker(chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deliveries []amqp.Delivery
+	for _, chunk := range chunker.Split(amqp.Publishing{Headers: amqp.Table{"source": "test"}, Body: []byte(body)}) {
+		deliveries = append(deliveries, amqp.Delivery{Acknowledger: &recordingAcknowledger{}, Headers: chunk.Headers, Body: chunk.Body})
+	}
+	return deliveries
+}
+
+func settlements(chunks []amqp.Delivery) [][]string {
+	var calls [][]string
+	for _, chunk := range chunks {
+		calls = append(calls, chunk.Acknowledger.(*recordingAcknowledger).settled())
+	}
+	return calls
+}
+
+func TestNewChunkerRejectsNonPositiveSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		if _, err := NewChunker(size); err == nil {
+			t.Errorf("NewChunker(%d) succeeded", size)
+		}
+	}
+}
+
+func TestChunkReassemblyOutOfOrder(t *testing.T) {
+	chunks := chunkDeliveries(t, 4, "0123456789")
+	if len(chunks) != 3 {
+		t.Fatalf("split into %d chunks, want 3", len(chunks))
+	}
+
+	var assembled []amqp.Delivery
+	consumer := NewChunkReassemblyMiddleware(ChunkReassemblyConfig{Timeout: time.Minute}, nil)(rabbitmq.ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+		assembled = append(assembled, msg)
+		_ = msg.Ack(false)
+	}))
+	for _, i := range []int{2, 0, 1} {
+		consumer.Consume(context.Background(), chunks[i])
+	}
+
+	if len(assembled) != 1 {
+		t.Fatalf("consumed %d messages, want one per transfer", len(assembled))
+	}
+	if string(assembled[0].Body) != "0123456789" {
+		t.Errorf("reassembled body %q", assembled[0].Body)
+	}
+	if !reflect.DeepEqual(assembled[0].Headers, amqp.Table{"source": "test"}) {
+		t.Errorf("reassembled headers %v, want chunk headers removed", assembled[0].Headers)
+	}
+	if calls := settlements(chunks); !reflect.DeepEqual(calls, [][]string{{"ack"}, {"ack"}, {"ack"}}) {
+		t.Errorf("chunks settled %q, want all acknowledged", calls)
+	}
+}
+
+func TestChunkReassemblyRejectsTransferWithMissingChunk(t *testing.T) {
+	chunks := chunkDeliveries(t, 4, "0123456789")
+
+	failed := make(chan error, 1)
+	consumer := NewChunkReassemblyMiddleware(ChunkReassemblyConfig{Timeout: 10 * time.Millisecond}, func(_ context.Context, _ amqp.Delivery, err error) {
+		failed <- err
+	})(rabbitmq.ConsumerFunc(func(context.Context, amqp.Delivery) {
+		t.Error("incomplete transfer was consumed")
+	}))
+	consumer.Consume(context.Background(), chunks[0])
+	consumer.Consume(context.Background(), chunks[2])
+
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("incomplete transfer didn't time out")
+	}
+
+	want := [][]string{{"nack requeue=false"}, nil, {"nack requeue=false"}}
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(settlements(chunks), want) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if calls := settlements(chunks); !reflect.DeepEqual(calls, want) {
+		t.Errorf("chunks settled %q, want received ones rejected", calls)
+	}
+}
+
+func TestChunkReassemblyRejectsTransferOverLimit(t *testing.T) {
+	large := chunkDeliveries(t, 4, "0123456789")
+	small := chunkDeliveries(t, 4, "abcdefgh")
+
+	var assembled []string
+	consumer := NewChunkReassemblyMiddleware(ChunkReassemblyConfig{MaxBytes: 8}, nil)(rabbitmq.ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+		assembled = append(assembled, string(msg.Body))
+		_ = msg.Ack(false)
+	}))
+	for _, chunk := range large {
+		consumer.Consume(context.Background(), chunk)
+	}
+
+	// the partial buffer is dropped, so the next transfer fits
+	for _, chunk := range small {
+		consumer.Consume(context.Background(), chunk)
+	}
+
+	if !reflect.DeepEqual(assembled, []string{"abcdefgh"}) {
+		t.Errorf("consumed %q, want only the transfer which fits", assembled)
+	}
+	want := [][]string{{"nack requeue=false"}, {"nack requeue=false"}, {"nack requeue=false"}}
+	if calls := settlements(large); !reflect.DeepEqual(calls, want) {
+		t.Errorf("chunks over limit settled %q, want rejected without requeue", calls)
+	}
+}
@@ -0,0 +1,237 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+const (
+	// headers of a reference message published instead of an oversized one
+	claimCheckRefHeader  = "x-claim-check-ref"
+	claimCheckSizeHeader = "x-claim-check-size"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps message bodies which are too large to be sent through the broker.
+// Get should return ErrBlobNotFound (or an error wrapping it) for unknown or already removed blobs.
+type BlobStore interface {
+	Put(ctx context.Context, body []byte) (ref string, err error)
+	Get(ctx context.Context, ref string) ([]byte, error)
+	Delete(ctx context.Context, ref string) error
+}
+
+// FileBlobStore keeps blobs as files in a directory, which may be a shared volume (NFS, SMB ...etc.)
+type FileBlobStore struct {
+	dir string
+}
+
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) Put(_ context.Context, body []byte) (string, error) {
+	ref := uuid.NewString()
+
+	// write into temporary file first, so that readers never see partially written blobs
+	tmp, err := os.CreateTemp(s.dir, ".tmp-"+ref)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, ref)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return ref, nil
+}
+
+func (s *FileBlobStore) Get(_ context.Context, ref string) ([]byte, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Join(ErrBlobNotFound, err)
+	}
+	return body, err
+}
+
+func (s *FileBlobStore) Delete(_ context.Context, ref string) error {
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes blobs older than ttl, it is meant for blobs which were never acknowledged
+// or which are shared between several queues and thus can't be removed on ack
+func (s *FileBlobStore) Cleanup(_ context.Context, ttl time.Duration) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(-ttl)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if info.ModTime().Before(deadline) {
+			if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RunCleanup calls Cleanup every interval until ctx is done
+func (s *FileBlobStore) RunCleanup(ctx context.Context, ttl, interval time.Duration, errCallback func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Cleanup(ctx, ttl); err != nil && errCallback != nil {
+				errCallback(err)
+			}
+		}
+	}
+}
+
+func (s *FileBlobStore) path(ref string) (string, error) {
+	// refs are generated by Put, anything else may be an attempt to escape the directory
+	if _, err := uuid.Parse(ref); err != nil {
+		return "", fmt.Errorf("invalid blob ref: %q", ref)
+	}
+	return filepath.Join(s.dir, ref), nil
+}
+
+// ClaimCheck publishes bodies larger than threshold into BlobStore and sends only a reference through the broker
+type ClaimCheck struct {
+	store     BlobStore
+	threshold int
+}
+
+func NewClaimCheck(store BlobStore, threshold int) *ClaimCheck {
+	return &ClaimCheck{store: store, threshold: threshold}
+}
+
+// Check returns msg as is when it fits threshold, otherwise it stores the body and returns a reference message
+func (c *ClaimCheck) Check(ctx context.Context, msg amqp.Publishing) (amqp.Publishing, error) {
+	if len(msg.Body) <= c.threshold {
+		return msg, nil
+	}
+
+	ref, err := c.store.Put(ctx, msg.Body)
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Headers = copyHeaders(msg.Headers)
+	msg.Headers[claimCheckRefHeader] = ref
+	msg.Headers[claimCheckSizeHeader] = int64(len(msg.Body))
+	msg.Body = nil
+
+	return msg, nil
+}
+
+// Publish checks message in and publishes it the same way as Publish does.
+// Stored blob is removed when publishing fails.
+func (c *ClaimCheck) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing, client *rabbitmq.Client) error {
+	checked, err := c.Check(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if err := Publish(ctx, exchange, key, checked, client); err != nil {
+		if ref, ok := checked.Headers[claimCheckRefHeader].(string); ok {
+			err = errors.Join(err, c.store.Delete(ctx, ref))
+		}
+		return err
+	}
+	return nil
+}
+
+type ClaimCheckErrCallback func(ctx context.Context, msg amqp.Delivery, err error)
+
+// NewClaimCheckMiddleware transparently replaces body of reference messages with the stored one.
+//
+// When deleteOnAck is set, blob is removed as soon as the message is acknowledged. Don't set it when the same
+// message is routed to several queues, rely on FileBlobStore.RunCleanup (or similar) with a TTL instead.
+//
+// Messages whose blob is gone are rejected without requeue, other failures requeue the message.
+func NewClaimCheckMiddleware(store BlobStore, deleteOnAck bool, cb ClaimCheckErrCallback) Middleware {
+	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
+		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
+			ref, ok := msg.Headers[claimCheckRefHeader].(string)
+			if !ok {
+				next.Consume(ctx, msg)
+				return
+			}
+
+			body, err := store.Get(ctx, ref)
+			if err != nil {
+				if cb != nil {
+					cb(ctx, msg, err)
+				}
+				requeue := !errors.Is(err, ErrBlobNotFound)
+				if err := msg.Nack(false, requeue); err != nil && cb != nil {
+					cb(ctx, msg, errors.Join(NewNackFailedError(msg), err))
+				}
+				return
+			}
+
+			msg.Headers = copyHeaders(msg.Headers)
+			delete(msg.Headers, claimCheckRefHeader)
+			delete(msg.Headers, claimCheckSizeHeader)
+			msg.Body = body
+
+			if deleteOnAck {
+				msg = withAckHooks(msg, func() {
+					if err := store.Delete(ctx, ref); err != nil && cb != nil {
+						cb(ctx, msg, err)
+					}
+				}, nil)
+			}
+
+			next.Consume(ctx, msg)
+		})
+	}
+}
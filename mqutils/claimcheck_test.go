@@ -0,0 +1,102 @@
+package mqutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+func TestClaimCheckRoundTrip(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := NewClaimCheck(store, 4)
+
+	small, err := check.Check(context.Background(), amqp.Publishing{Body: []byte("tiny")})
+	if err != nil || string(small.Body) != "tiny" || small.Headers != nil {
+		t.Errorf("Check() of small message = %q, %v, %v, want it as is", small.Body, small.Headers, err)
+	}
+
+	large := bytes.Repeat([]byte("x"), 100)
+	checked, err := check.Check(context.Background(), amqp.Publishing{Headers: amqp.Table{"source": "test"}, Body: large})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checked.Body) != 0 || checked.Headers[claimCheckSizeHeader] != int64(100) {
+		t.Fatalf("checked message %q with headers %v, want a reference", checked.Body, checked.Headers)
+	}
+
+	var consumed amqp.Delivery
+	consumer := NewClaimCheckMiddleware(store, true, nil)(rabbitmq.ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+		consumed = msg
+		_ = msg.Ack(false)
+	}))
+	ack := &recordingAcknowledger{}
+	consumer.Consume(context.Background(), amqp.Delivery{Acknowledger: ack, Headers: checked.Headers})
+
+	if !bytes.Equal(consumed.Body, large) {
+		t.Errorf("consumed body of %d bytes, want the stored one", len(consumed.Body))
+	}
+	if !reflect.DeepEqual(consumed.Headers, amqp.Table{"source": "test"}) {
+		t.Errorf("consumed headers %v, want claim check headers removed", consumed.Headers)
+	}
+	if !reflect.DeepEqual(ack.calls, []string{"ack"}) {
+		t.Errorf("settled %q, want acked", ack.calls)
+	}
+
+	// the blob is removed on ack, so a redelivered reference is rejected
+	ref := checked.Headers[claimCheckRefHeader].(string)
+	if _, err := store.Get(context.Background(), ref); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get() of acknowledged blob error = %v, want ErrBlobNotFound", err)
+	}
+	redelivered := &recordingAcknowledger{}
+	consumer.Consume(context.Background(), amqp.Delivery{Acknowledger: redelivered, Headers: checked.Headers})
+	if !reflect.DeepEqual(redelivered.calls, []string{"nack requeue=false"}) {
+		t.Errorf("reference to removed blob settled %q, want rejected", redelivered.calls)
+	}
+}
+
+func TestFileBlobStoreCleanup(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := store.Put(context.Background(), []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := store.Put(context.Background(), []byte("recent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, old), hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Cleanup(context.Background(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(context.Background(), old); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get() of expired blob error = %v, want ErrBlobNotFound", err)
+	}
+	if body, err := store.Get(context.Background(), recent); err != nil || string(body) != "recent" {
+		t.Errorf("Get() of recent blob = %q, %v", body, err)
+	}
+
+	if _, err := store.Get(context.Background(), "../outside"); err == nil {
+		t.Error("Get() accepted a ref escaping the directory")
+	}
+}
@@ -0,0 +1,85 @@
+package mqutils
+
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// header appended by the broker every time a message is dead-lettered
+const deathHeader = "x-death"
+
+// Reasons a message is dead-lettered for
+const (
+	DeathRejected      = "rejected"
+	DeathExpired       = "expired"
+	DeathMaxLen        = "maxlen"
+	DeathDeliveryLimit = "delivery_limit"
+)
+
+// Death is an entry of x-death header, there is an entry per queue and reason, the most recent one first
+type Death struct {
+	// how many times the message was dead-lettered from the queue for the reason
+	Count  int64
+	Reason string
+	// queue the message was dead-lettered from
+	Queue string
+	// exchange and routing keys the message was published with to the queue
+	Exchange    string
+	RoutingKeys []string
+	// when the message was dead-lettered from the queue for the reason first time
+	Time time.Time
+	// set when the message expired by its own expiration
+	OriginalExpiration string
+}
+
+// Deaths parses x-death header of the message, entries of unexpected format are skipped
+func Deaths(msg amqp.Delivery) []Death {
+	entries, ok := msg.Headers[deathHeader].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	deaths := make([]Death, 0, len(entries))
+	for _, entry := range entries {
+		table, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+
+		death := Death{}
+		death.Count, _ = headerInt(table["count"])
+		death.Reason, _ = table["reason"].(string)
+		death.Queue, _ = table["queue"].(string)
+		death.Exchange, _ = table["exchange"].(string)
+		death.Time, _ = table["time"].(time.Time)
+		death.OriginalExpiration, _ = table["original-expiration"].(string)
+		if keys, ok := table["routing-keys"].([]interface{}); ok {
+			for _, key := range keys {
+				if s, ok := key.(string); ok {
+					death.RoutingKeys = append(death.RoutingKeys, s)
+				}
+			}
+		}
+		deaths = append(deaths, death)
+	}
+	return deaths
+}
+
+// LastDeath returns the most recent x-death entry, false if the message was never dead-lettered
+func LastDeath(msg amqp.Delivery) (Death, bool) {
+	deaths := Deaths(msg)
+	if len(deaths) == 0 {
+		return Death{}, false
+	}
+	return deaths[0], true
+}
+
+// DeathCount returns how many times the message was dead-lettered in total
+func DeathCount(msg amqp.Delivery) int64 {
+	var count int64
+	for _, death := range Deaths(msg) {
+		count += death.Count
+	}
+	return count
+}
@@ -0,0 +1,70 @@
+package mqutils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDeaths(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	msg := amqp.Delivery{Headers: amqp.Table{
+		deathHeader: []interface{}{
+			amqp.Table{
+				"count":        int64(2),
+				"reason":       DeathExpired,
+				"queue":        "orders.wait",
+				"exchange":     "orders",
+				"routing-keys": []interface{}{"order.created", "order.any"},
+				"time":         at,
+			},
+			"not a table",
+			amqp.Table{
+				"count":               int32(1),
+				"reason":              DeathRejected,
+				"queue":               "orders",
+				"original-expiration": "60000",
+			},
+		},
+	}}
+
+	want := []Death{
+		{
+			Count:       2,
+			Reason:      DeathExpired,
+			Queue:       "orders.wait",
+			Exchange:    "orders",
+			RoutingKeys: []string{"order.created", "order.any"},
+			Time:        at,
+		},
+		{
+			Count:              1,
+			Reason:             DeathRejected,
+			Queue:              "orders",
+			OriginalExpiration: "60000",
+		},
+	}
+	if got := Deaths(msg); !reflect.DeepEqual(got, want) {
+		t.Errorf("Deaths() = %+v, want %+v", got, want)
+	}
+
+	if last, ok := LastDeath(msg); !ok || last.Queue != "orders.wait" {
+		t.Errorf("LastDeath() = %+v, %t", last, ok)
+	}
+	if count := DeathCount(msg); count != 3 {
+		t.Errorf("DeathCount() = %d, want 3", count)
+	}
+}
+
+func TestDeathsOfMessageNeverDeadLettered(t *testing.T) {
+	msg := amqp.Delivery{Headers: amqp.Table{}}
+
+	if deaths := Deaths(msg); deaths != nil {
+		t.Errorf("Deaths() = %+v, want nil", deaths)
+	}
+	if _, ok := LastDeath(msg); ok {
+		t.Error("LastDeath() found a death")
+	}
+}
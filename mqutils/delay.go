@@ -0,0 +1,256 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+const (
+	// original destination of a delayed message
+	delayExchangeHeader   = "x-delay-exchange"
+	delayRoutingKeyHeader = "x-delay-routing-key"
+	// header read by delayed-message-exchange plugin, delay in milliseconds
+	delayPluginHeader = "x-delay"
+	// header which routes a message to the wait queue of a tier
+	delayTierHeader = "x-delay-tier"
+
+	delayedMessageExchangeType = "x-delayed-message"
+)
+
+var DefaultDelayTiers = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+}
+
+type DelayedPublisherConfig struct {
+	// wait queue tiers used without delayed-message-exchange plugin, DefaultDelayTiers by default
+	Tiers []time.Duration
+	// whether rabbitmq_delayed_message_exchange plugin is used, it's detected on the first delayed publish
+	// when not set. Detection declares an exchange of the plugin type on a separate connection,
+	// a failure of which means the plugin is absent.
+	UsePlugin *bool
+}
+
+// DelayedPublisher publishes messages which are delivered to their exchange not earlier than after a delay.
+//
+// When rabbitmq_delayed_message_exchange plugin is available, every target exchange gets an
+// "{exchange}.delayed" companion exchange bound to it. Otherwise, messages wait in "{exchange}.wait.{tier}"
+// queues with per-queue TTL, which dead-letter them back to the target exchange with the original routing key.
+// In the latter case delays are rounded to the nearest tier, and delays beyond the largest tier are refused
+// with *DelayTooLongError rather than delivered early.
+type DelayedPublisher struct {
+	client *rabbitmq.Client
+	tiers  []time.Duration
+	// nil until the plugin is probed
+	plugin *bool
+
+	// exchanges with already declared delay topology
+	declared map[string]bool
+	mx       sync.Mutex
+}
+
+func NewDelayedPublisher(client *rabbitmq.Client, cfg DelayedPublisherConfig) *DelayedPublisher {
+	tiers := append([]time.Duration{}, cfg.Tiers...)
+	if len(tiers) == 0 {
+		tiers = append(tiers, DefaultDelayTiers...)
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i] < tiers[j] })
+
+	p := &DelayedPublisher{
+		client:   client,
+		tiers:    tiers,
+		declared: make(map[string]bool),
+	}
+	if cfg.UsePlugin != nil {
+		usePlugin := *cfg.UsePlugin
+		p.plugin = &usePlugin
+	}
+	return p
+}
+
+// PublishAt publishes message to be delivered at the given time
+func (p *DelayedPublisher) PublishAt(ctx context.Context, at time.Time, exchange, key string, msg amqp.Publishing) error {
+	return p.PublishAfter(ctx, time.Until(at), exchange, key, msg)
+}
+
+// PublishAfter publishes message to be delivered after the given delay, non-positive delay publishes it right away
+func (p *DelayedPublisher) PublishAfter(ctx context.Context, delay time.Duration, exchange, key string, msg amqp.Publishing) error {
+	if delay <= 0 {
+		return Publish(ctx, exchange, key, msg, p.client)
+	}
+
+	// the default exchange can't be a binding destination, so the plugin is of no use for it
+	usePlugin := false
+	if exchange != "" {
+		var err error
+		if usePlugin, err = p.pluginEnabled(); err != nil {
+			return err
+		}
+	}
+
+	var tier time.Duration
+	if !usePlugin {
+		var err error
+		if tier, err = waitTier(p.tiers, delay); err != nil {
+			return err
+		}
+	}
+
+	if err := p.declare(exchange, usePlugin); err != nil {
+		return err
+	}
+
+	msg.Headers = copyHeaders(msg.Headers)
+	msg.Headers[delayExchangeHeader] = exchange
+	msg.Headers[delayRoutingKeyHeader] = key
+
+	if usePlugin {
+		msg.Headers[delayPluginHeader] = delay.Milliseconds()
+		return Publish(ctx, delayedExchangeName(exchange), key, msg, p.client)
+	}
+
+	msg.Headers[delayTierHeader] = tier.String()
+	return Publish(ctx, waitExchangeName(exchange), key, msg, p.client)
+}
+
+// pluginEnabled probes delayed-message-exchange plugin once, unless it's set by DelayedPublisherConfig.UsePlugin
+func (p *DelayedPublisher) pluginEnabled() (bool, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.plugin != nil {
+		return *p.plugin, nil
+	}
+
+	var enabled bool
+	err := p.client.WithIsolatedChannel(func(ch *amqp.Channel) error {
+		probe := fmt.Sprintf("delayed-message-probe.%s", uuid.NewString())
+		args := amqp.Table{"x-delayed-type": amqp.ExchangeDirect}
+		if err := ch.ExchangeDeclare(probe, delayedMessageExchangeType, false, true, false, false, args); err != nil {
+			var amqpErr *amqp.Error
+			if errors.As(err, &amqpErr) {
+				// the broker closes the channel (or the connection) on unknown exchange type
+				return nil
+			}
+			return err
+		}
+		enabled = true
+		return ch.ExchangeDelete(probe, false, false)
+	})
+	if err != nil {
+		return false, err
+	}
+	p.plugin = &enabled
+	return enabled, nil
+}
+
+// declare makes sure delay topology of the exchange exists
+func (p *DelayedPublisher) declare(exchange string, usePlugin bool) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.declared[exchange] {
+		return nil
+	}
+	if err := p.client.WithChannel(func(ch *amqp.Channel) error {
+		if usePlugin {
+			return declareDelayedExchange(ch, exchange)
+		}
+		return declareWaitQueues(ch, waitExchangeName(exchange), exchange, "", p.tiers)
+	}); err != nil {
+		return err
+	}
+	p.declared[exchange] = true
+	return nil
+}
+
+func declareDelayedExchange(ch *amqp.Channel, exchange string) error {
+	name := delayedExchangeName(exchange)
+	args := amqp.Table{"x-delayed-type": amqp.ExchangeFanout}
+	if err := ch.ExchangeDeclare(name, delayedMessageExchangeType, true, false, false, false, args); err != nil {
+		return err
+	}
+	// exchange-to-exchange binding keeps the routing key, so target exchange routes the message as usual
+	return ch.ExchangeBind(exchange, "", name, false, nil)
+}
+
+// declareWaitQueues declares a headers exchange routing messages into a wait queue per tier by delayTierHeader.
+// Expired messages are dead-lettered into deadLetterExchange with deadLetterKey or, when empty, the original key.
+func declareWaitQueues(ch *amqp.Channel, waitExchange, deadLetterExchange, deadLetterKey string, tiers []time.Duration) error {
+	if err := ch.ExchangeDeclare(waitExchange, amqp.ExchangeHeaders, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	for _, tier := range tiers {
+		queue := fmt.Sprintf("%s.%s", waitExchange, tier)
+		args := amqp.Table{
+			"x-message-ttl":          tier.Milliseconds(),
+			"x-dead-letter-exchange": deadLetterExchange,
+		}
+		if deadLetterKey != "" {
+			args["x-dead-letter-routing-key"] = deadLetterKey
+		}
+		if _, err := ch.QueueDeclare(queue, true, false, false, false, args); err != nil {
+			return err
+		}
+
+		bindArgs := amqp.Table{"x-match": "all", delayTierHeader: tier.String()}
+		if err := ch.QueueBind(queue, "", waitExchange, false, bindArgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitTier returns the tier closest to delay, a delay beyond the largest tier can't be served by wait queues
+func waitTier(tiers []time.Duration, delay time.Duration) (time.Duration, error) {
+	if largest := tiers[len(tiers)-1]; delay > largest {
+		return 0, NewDelayTooLongError(delay, largest)
+	}
+	return NearestTier(tiers, delay), nil
+}
+
+// NearestTier returns the tier closest to delay, tiers must be sorted
+func NearestTier(tiers []time.Duration, delay time.Duration) time.Duration {
+	nearest := tiers[0]
+	for _, tier := range tiers[1:] {
+		if absDuration(tier-delay) < absDuration(nearest-delay) {
+			nearest = tier
+		}
+	}
+	return nearest
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func delayedExchangeName(exchange string) string {
+	return fmt.Sprintf("%s.delayed", exchange)
+}
+
+func waitExchangeName(exchange string) string {
+	if exchange == "" {
+		exchange = "default"
+	}
+	return fmt.Sprintf("%s.wait", exchange)
+}
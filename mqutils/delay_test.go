@@ -0,0 +1,55 @@
+package mqutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+func TestNearestTier(t *testing.T) {
+	tiers := []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+	tests := []struct {
+		delay time.Duration
+		want  time.Duration
+	}{
+		{delay: time.Millisecond, want: time.Second},
+		{delay: time.Second, want: time.Second},
+		{delay: 4 * time.Second, want: time.Second},
+		{delay: 7 * time.Second, want: 10 * time.Second},
+		{delay: 40 * time.Second, want: time.Minute},
+		{delay: time.Hour, want: time.Minute},
+	}
+	for _, tt := range tests {
+		if got := NearestTier(tiers, tt.delay); got != tt.want {
+			t.Errorf("NearestTier(%s) = %s, want %s", tt.delay, got, tt.want)
+		}
+	}
+}
+
+func TestWaitTierRefusesDelayBeyondLargestTier(t *testing.T) {
+	tiers := []time.Duration{time.Second, time.Hour}
+
+	if tier, err := waitTier(tiers, time.Hour); err != nil || tier != time.Hour {
+		t.Errorf("waitTier(1h) = %s, %v, want 1h, nil", tier, err)
+	}
+
+	_, err := waitTier(tiers, 24*time.Hour)
+	var tooLong *DelayTooLongError
+	if !errors.As(err, &tooLong) {
+		t.Fatalf("waitTier(24h) error = %v, want *DelayTooLongError", err)
+	}
+}
+
+func TestUsePluginOverridesProbe(t *testing.T) {
+	for _, usePlugin := range []bool{true, false} {
+		// the client isn't connected, so probing it would fail
+		p := NewDelayedPublisher(&rabbitmq.Client{}, DelayedPublisherConfig{UsePlugin: &usePlugin})
+
+		if enabled, err := p.pluginEnabled(); err != nil || enabled != usePlugin {
+			t.Errorf("pluginEnabled() = %t, %v, want %t set by config", enabled, err, usePlugin)
+		}
+	}
+}
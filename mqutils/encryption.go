@@ -0,0 +1,291 @@
+package mqutils
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+const (
+	// headers describing how a message body was encrypted
+	encryptionKeyIDHeader   = "x-encryption-key-id"
+	encryptionAlgHeader     = "x-encryption-alg"
+	encryptionDataKeyHeader = "x-encryption-data-key"
+
+	// EncryptionAlgAESGCM is the only algorithm supported at the moment: AES-256 in GCM mode
+	EncryptionAlgAESGCM = "AES-256-GCM"
+
+	dataKeySize = 32
+)
+
+// ErrNotEncrypted is returned by Decrypt for messages without encryption headers
+var ErrNotEncrypted = errors.New("message is not encrypted")
+
+// KeyProvider wraps (encrypts) and unwraps (decrypts) per-message data keys with master keys
+// identified by key id. It may be backed by a KMS, vault or any other secret storage.
+//
+// During key rotation several keys may be active at once: new messages are encrypted with CurrentKeyID,
+// while UnwrapKey should still accept all previous keys until their messages are drained.
+type KeyProvider interface {
+	CurrentKeyID(ctx context.Context) (string, error)
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey should return UnknownKeyError when keyID is not (or no longer) known
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// StaticKeyProvider keeps master keys in memory, keys must be 32 bytes long (AES-256)
+type StaticKeyProvider struct {
+	currentKeyID string
+	keys         map[string]cipher.AEAD
+	rw           sync.RWMutex
+}
+
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{keys: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if err := p.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.SetCurrentKeyID(currentKeyID); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AddKey registers a new master key, it can be used for decryption right away
+func (p *StaticKeyProvider) AddKey(keyID string, key []byte) error {
+	if len(key) != dataKeySize {
+		return fmt.Errorf("master key %q must be %d bytes long, got %d", keyID, dataKeySize, len(key))
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return err
+	}
+
+	p.rw.Lock()
+	defer p.rw.Unlock()
+
+	p.keys[keyID] = aead
+	return nil
+}
+
+// RemoveKey retires a master key, messages encrypted with it can't be decrypted anymore
+func (p *StaticKeyProvider) RemoveKey(keyID string) {
+	p.rw.Lock()
+	defer p.rw.Unlock()
+
+	delete(p.keys, keyID)
+}
+
+// SetCurrentKeyID switches encryption of new messages to another (already added) key
+func (p *StaticKeyProvider) SetCurrentKeyID(keyID string) error {
+	p.rw.Lock()
+	defer p.rw.Unlock()
+
+	if _, ok := p.keys[keyID]; !ok {
+		return NewUnknownKeyError(keyID)
+	}
+	p.currentKeyID = keyID
+	return nil
+}
+
+func (p *StaticKeyProvider) CurrentKeyID(_ context.Context) (string, error) {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+
+	return p.currentKeyID, nil
+}
+
+func (p *StaticKeyProvider) WrapKey(_ context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := p.get(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+func (p *StaticKeyProvider) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := p.get(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+func (p *StaticKeyProvider) get(keyID string) (cipher.AEAD, error) {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, NewUnknownKeyError(keyID)
+	}
+	return aead, nil
+}
+
+// Encrypter is a publish-side envelope encryption: every message body is encrypted with a fresh data key,
+// which is wrapped by KeyProvider and sent along in headers together with key id and algorithm
+type Encrypter struct {
+	keys KeyProvider
+}
+
+func NewEncrypter(keys KeyProvider) *Encrypter {
+	return &Encrypter{keys: keys}
+}
+
+// Encrypt returns a copy of msg with encrypted body and encryption headers, msg itself is left untouched
+func (e *Encrypter) Encrypt(ctx context.Context, msg amqp.Publishing) (amqp.Publishing, error) {
+	keyID, err := e.keys.CurrentKeyID(ctx)
+	if err != nil {
+		return msg, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return msg, err
+	}
+	wrappedKey, err := e.keys.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return msg, err
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return msg, err
+	}
+	body, err := seal(aead, msg.Body, []byte(keyID))
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Headers = copyHeaders(msg.Headers)
+	msg.Headers[encryptionKeyIDHeader] = keyID
+	msg.Headers[encryptionAlgHeader] = EncryptionAlgAESGCM
+	msg.Headers[encryptionDataKeyHeader] = wrappedKey
+	msg.Body = body
+
+	return msg, nil
+}
+
+// Publish encrypts message and publishes it the same way as Publish does
+func (e *Encrypter) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing, client *rabbitmq.Client) error {
+	encrypted, err := e.Encrypt(ctx, msg)
+	if err != nil {
+		return err
+	}
+	return Publish(ctx, exchange, key, encrypted, client)
+}
+
+// Decrypt returns a copy of msg with decrypted body, messages without encryption headers are returned as is
+// along with ErrNotEncrypted
+func Decrypt(ctx context.Context, keys KeyProvider, msg amqp.Delivery) (amqp.Delivery, error) {
+	keyID, ok := msg.Headers[encryptionKeyIDHeader].(string)
+	if !ok {
+		return msg, ErrNotEncrypted
+	}
+	if alg, _ := msg.Headers[encryptionAlgHeader].(string); alg != EncryptionAlgAESGCM {
+		return msg, fmt.Errorf("unsupported encryption algorithm: %q", alg)
+	}
+	wrappedKey, ok := msg.Headers[encryptionDataKeyHeader].([]byte)
+	if !ok {
+		return msg, errors.New("encrypted message has no data key")
+	}
+
+	dataKey, err := keys.UnwrapKey(ctx, keyID, wrappedKey)
+	if err != nil {
+		return msg, err
+	}
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return msg, err
+	}
+	body, err := open(aead, msg.Body, []byte(keyID))
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Headers = copyHeaders(msg.Headers)
+	delete(msg.Headers, encryptionKeyIDHeader)
+	delete(msg.Headers, encryptionAlgHeader)
+	delete(msg.Headers, encryptionDataKeyHeader)
+	msg.Body = body
+
+	return msg, nil
+}
+
+type DecryptionConfig struct {
+	// pass messages without encryption headers as is, e.g. while publishers are being switched to Encrypter.
+	// Otherwise, they are rejected the same way as messages which can't be decrypted.
+	AllowPlaintext bool
+}
+
+type DecryptionErrCallback func(ctx context.Context, msg amqp.Delivery, err error)
+
+// NewDecryptionMiddleware decrypts messages published by Encrypter before passing them further.
+// Messages which can't be decrypted (e.g. encrypted with unknown or retired key) are rejected without requeue,
+// so they end up in dead-letter queue if one is configured.
+func NewDecryptionMiddleware(keys KeyProvider, cfg DecryptionConfig, cb DecryptionErrCallback) Middleware {
+	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
+		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
+			decrypted, err := Decrypt(ctx, keys, msg)
+			if errors.Is(err, ErrNotEncrypted) && cfg.AllowPlaintext {
+				err = nil
+			}
+			if err != nil {
+				if cb != nil {
+					cb(ctx, msg, err)
+				}
+				if err := msg.Nack(false, false); err != nil && cb != nil {
+					cb(ctx, msg, errors.Join(NewNackFailedError(msg), err))
+				}
+				return
+			}
+
+			next.Consume(ctx, decrypted)
+		})
+	}
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce followed by ciphertext
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// copyHeaders makes a shallow copy, so that callers' tables are never mutated
+func copyHeaders(headers amqp.Table) amqp.Table {
+	table := make(amqp.Table, len(headers)+3)
+	for k, v := range headers {
+		table[k] = v
+	}
+	return table
+}
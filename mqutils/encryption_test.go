@@ -0,0 +1,128 @@
+package mqutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, dataKeySize)
+}
+
+func encrypted(t *testing.T, keys KeyProvider, msg amqp.Publishing) amqp.Delivery {
+	t.Helper()
+
+	enc, err := NewEncrypter(keys).Encrypt(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{Headers: enc.Headers, Body: enc.Body}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := amqp.Publishing{Headers: amqp.Table{"source": "test"}, Body: []byte("card number")}
+
+	delivery := encrypted(t, keys, msg)
+	if bytes.Contains(delivery.Body, msg.Body) {
+		t.Fatal("body is not encrypted")
+	}
+	if len(msg.Headers) != 1 {
+		t.Errorf("original headers were modified: %v", msg.Headers)
+	}
+
+	decrypted, err := Decrypt(context.Background(), keys, delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted.Body) != "card number" {
+		t.Errorf("decrypted body %q", decrypted.Body)
+	}
+	if len(decrypted.Headers) != 1 || decrypted.Headers["source"] != "test" {
+		t.Errorf("decrypted headers %v, want encryption headers removed", decrypted.Headers)
+	}
+}
+
+func TestDecryptAfterKeyRotation(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := encrypted(t, keys, amqp.Publishing{Body: []byte("old")})
+
+	if err := keys.AddKey("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.SetCurrentKeyID("k2"); err != nil {
+		t.Fatal(err)
+	}
+	recent := encrypted(t, keys, amqp.Publishing{Body: []byte("recent")})
+	if recent.Headers[encryptionKeyIDHeader] != "k2" {
+		t.Errorf("encrypted with %v, want the current key k2", recent.Headers[encryptionKeyIDHeader])
+	}
+
+	for _, msg := range []amqp.Delivery{old, recent} {
+		if _, err := Decrypt(context.Background(), keys, msg); err != nil {
+			t.Errorf("decrypting message of key %v: %v", msg.Headers[encryptionKeyIDHeader], err)
+		}
+	}
+
+	keys.RemoveKey("k1")
+	var unknownKey *UnknownKeyError
+	if _, err := Decrypt(context.Background(), keys, old); !errors.As(err, &unknownKey) {
+		t.Errorf("decrypting message of retired key error = %v, want *UnknownKeyError", err)
+	}
+}
+
+func TestDecryptRejectsTamperedMessage(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := encrypted(t, keys, amqp.Publishing{Body: []byte("amount: 10")})
+	delivery.Body[len(delivery.Body)-1] ^= 1
+
+	if _, err := Decrypt(context.Background(), keys, delivery); err == nil {
+		t.Error("tampered message was decrypted")
+	}
+}
+
+func TestDecryptionMiddlewareRejectsPlainMessage(t *testing.T) {
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, allowPlaintext := range []bool{false, true} {
+		var consumed []string
+		consumer := NewDecryptionMiddleware(keys, DecryptionConfig{AllowPlaintext: allowPlaintext}, nil)(
+			rabbitmq.ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+				consumed = append(consumed, string(msg.Body))
+			}),
+		)
+
+		ack := &recordingAcknowledger{}
+		consumer.Consume(context.Background(), amqp.Delivery{Acknowledger: ack, Body: []byte("plain")})
+
+		if allowPlaintext && (!reflect.DeepEqual(consumed, []string{"plain"}) || len(ack.calls) != 0) {
+			t.Errorf("allowed plaintext: consumed %q, settled %q, want passed as is", consumed, ack.calls)
+		}
+		if !allowPlaintext && (len(consumed) != 0 || !reflect.DeepEqual(ack.calls, []string{"nack requeue=false"})) {
+			t.Errorf("consumed %q, settled %q, want plain message rejected", consumed, ack.calls)
+		}
+	}
+
+	if _, err := Decrypt(context.Background(), keys, amqp.Delivery{Body: []byte("plain")}); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Decrypt() of plain message error = %v, want ErrNotEncrypted", err)
+	}
+}
@@ -2,6 +2,7 @@ package mqutils
 
 import (
 	"fmt"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -41,3 +42,130 @@ func (err *NackFailedError) Error() string {
 func (err *AckFailedError) Error() string {
 	return fmt.Sprintf("ACK failed for routing_key: %s", err.msg.RoutingKey)
 }
+
+// UnknownKeyError is returned by KeyProvider when a key id is not (or no longer) known
+type UnknownKeyError struct {
+	keyID string
+}
+
+func NewUnknownKeyError(keyID string) *UnknownKeyError {
+	return &UnknownKeyError{keyID: keyID}
+}
+
+func (err *UnknownKeyError) Error() string {
+	return fmt.Sprintf("unknown key id: %s", err.keyID)
+}
+
+// InvalidSignatureError is returned when message signature can't be verified
+type InvalidSignatureError struct {
+	msg    amqp.Delivery
+	reason string
+}
+
+func NewInvalidSignatureError(msg amqp.Delivery, reason string) *InvalidSignatureError {
+	return &InvalidSignatureError{msg: msg, reason: reason}
+}
+
+func (err *InvalidSignatureError) Error() string {
+	return fmt.Sprintf("invalid signature for routing_key: %s, reason: %s", err.msg.RoutingKey, err.reason)
+}
+
+// PoisonMessageError is passed to ErrorHandler when a message exceeds its redelivery limit and is parked
+type PoisonMessageError struct {
+	msg        amqp.Delivery
+	deliveries int64
+	err        error
+}
+
+func NewPoisonMessageError(msg amqp.Delivery, deliveries int64, err error) *PoisonMessageError {
+	return &PoisonMessageError{msg: msg, deliveries: deliveries, err: err}
+}
+
+func (err *PoisonMessageError) Error() string {
+	if err.err == nil {
+		return fmt.Sprintf("message delivered %d times for routing_key: %s", err.deliveries, err.msg.RoutingKey)
+	}
+	return fmt.Sprintf("message delivered %d times for routing_key: %s, last error: %s",
+		err.deliveries, err.msg.RoutingKey, err.err)
+}
+
+func (err *PoisonMessageError) Unwrap() error {
+	return err.err
+}
+
+// RepublishFailedError is returned when a message can't be sent to a retry or parking queue
+type RepublishFailedError struct {
+	msg amqp.Delivery
+}
+
+func NewRepublishFailedError(msg amqp.Delivery) *RepublishFailedError {
+	return &RepublishFailedError{msg: msg}
+}
+
+func (err *RepublishFailedError) Error() string {
+	return fmt.Sprintf("republish failed for routing_key: %s", err.msg.RoutingKey)
+}
+
+// RetriesExhaustedError is passed to ErrorHandler when a message failed after all retries of RetryPolicy
+type RetriesExhaustedError struct {
+	msg      amqp.Delivery
+	attempts int64
+	err      error
+}
+
+func NewRetriesExhaustedError(msg amqp.Delivery, attempts int64, err error) *RetriesExhaustedError {
+	return &RetriesExhaustedError{msg: msg, attempts: attempts, err: err}
+}
+
+func (err *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("message failed after %d retries for routing_key: %s, last error: %s",
+		err.attempts, err.msg.RoutingKey, err.err)
+}
+
+func (err *RetriesExhaustedError) Unwrap() error {
+	return err.err
+}
+
+// HandlerTimeoutError is passed to ErrorHandler when a handler didn't finish within TimeoutMiddleware timeout
+type HandlerTimeoutError struct {
+	msg     amqp.Delivery
+	timeout time.Duration
+}
+
+func NewHandlerTimeoutError(msg amqp.Delivery, timeout time.Duration) *HandlerTimeoutError {
+	return &HandlerTimeoutError{msg: msg, timeout: timeout}
+}
+
+func (err *HandlerTimeoutError) Error() string {
+	return fmt.Sprintf("handler timed out after %s for routing_key: %s", err.timeout, err.msg.RoutingKey)
+}
+
+// ConsumerTimeoutWarning reports a handler timeout close to the broker's consumer_timeout
+type ConsumerTimeoutWarning struct {
+	timeout         time.Duration
+	consumerTimeout time.Duration
+}
+
+func NewConsumerTimeoutWarning(timeout, consumerTimeout time.Duration) *ConsumerTimeoutWarning {
+	return &ConsumerTimeoutWarning{timeout: timeout, consumerTimeout: consumerTimeout}
+}
+
+func (err *ConsumerTimeoutWarning) Error() string {
+	return fmt.Sprintf("handler timeout %s is close to consumer_timeout %s of the broker, "+
+		"deliveries waiting in prefetch buffer may exceed it", err.timeout, err.consumerTimeout)
+}
+
+// DelayTooLongError is returned when a delay exceeds the largest wait queue tier
+type DelayTooLongError struct {
+	delay   time.Duration
+	largest time.Duration
+}
+
+func NewDelayTooLongError(delay, largest time.Duration) *DelayTooLongError {
+	return &DelayTooLongError{delay: delay, largest: largest}
+}
+
+func (err *DelayTooLongError) Error() string {
+	return fmt.Sprintf("delay %s exceeds the largest tier %s, add a larger tier or enable delayed-message-exchange plugin",
+		err.delay, err.largest)
+}
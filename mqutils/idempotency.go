@@ -0,0 +1,363 @@
+package mqutils
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alifcapital/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultIdempotencyLease = 5 * time.Minute
+	// bounds of the interval between lookups of a key in progress
+	minIdempotencyPoll = 100 * time.Millisecond
+	maxIdempotencyPoll = 5 * time.Second
+)
+
+type IdempotencyState int
+
+const (
+	// the key was unknown (or expired) and now it's marked as in progress by the caller
+	IdempotencyAcquired IdempotencyState = iota
+	// another delivery with the key is being handled
+	IdempotencyInProgress
+	// a delivery with the key was handled and acknowledged
+	IdempotencyCompleted
+)
+
+// IdempotencyStore keeps processing state of message keys
+type IdempotencyStore interface {
+	// Acquire marks the key as in progress for the lease, unless it's in progress or completed already
+	Acquire(ctx context.Context, key string, lease time.Duration) (IdempotencyState, error)
+	// Complete marks the key as completed, the store decides how long completed keys are kept
+	Complete(ctx context.Context, key string) error
+	// Release forgets the in progress key, so that the message can be handled again
+	Release(ctx context.Context, key string) error
+}
+
+// MessageIDKey is the default idempotency key
+func MessageIDKey(msg amqp.Delivery) string {
+	return msg.MessageId
+}
+
+// copyKey makes keys of copies republished by Router for retries and redelivery counting distinct,
+// since the original delivery is acknowledged (and so its key is completed) when a copy is published
+func copyKey(key string, msg amqp.Delivery) string {
+	return fmt.Sprintf("%s:%d:%d", key, RetryAttempt(msg), headerIntOrZero(msg.Headers[redeliveryCountHeader]))
+}
+
+func headerIntOrZero(value any) int64 {
+	n, _ := headerInt(value)
+	return n
+}
+
+type IdempotencyConfig struct {
+	// MessageIDKey when not set, messages with empty key are handled as usual.
+	// Retry attempt and redelivery count of Router are appended to the key.
+	Key func(msg amqp.Delivery) string
+	// how long a key stays in progress when the message is neither acked nor nacked,
+	// e.g. the process crashed in the middle, 5 minutes when not set
+	Lease time.Duration
+}
+
+type IdempotencyErrCallback func(ctx context.Context, msg amqp.Delivery, err error)
+
+// NewIdempotencyMiddleware skips messages which were already handled.
+//
+// The key is marked as in progress before the handler is called, as completed once the message is acked,
+// and released once it's nacked or rejected, so that a redelivery is handled again. Duplicates of completed
+// messages are acked without calling the handler. A duplicate arriving while the first delivery is still
+// being handled holds its worker and polls the key until the first one completes (the duplicate is skipped),
+// fails or its lease expires (the duplicate is handled). It's requeued if its context is done meanwhile.
+//
+// Consumer must use manual acknowledgement, otherwise keys are never completed.
+func NewIdempotencyMiddleware(store IdempotencyStore, cfg IdempotencyConfig, cb IdempotencyErrCallback) Middleware {
+	keyFunc := cfg.Key
+	if keyFunc == nil {
+		keyFunc = MessageIDKey
+	}
+	lease := cfg.Lease
+	if lease <= 0 {
+		lease = defaultIdempotencyLease
+	}
+
+	report := func(ctx context.Context, msg amqp.Delivery, err error) {
+		if cb != nil {
+			cb(ctx, msg, err)
+		}
+	}
+
+	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
+		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
+			key := keyFunc(msg)
+			if key == "" {
+				next.Consume(ctx, msg)
+				return
+			}
+			key = copyKey(key, msg)
+
+			state, err := acquireOrWait(ctx, store, key, lease)
+			if err != nil {
+				report(ctx, msg, err)
+				if err := msg.Nack(false, true); err != nil {
+					report(ctx, msg, errors.Join(NewNackFailedError(msg), err))
+				}
+				return
+			}
+
+			switch state {
+			case IdempotencyCompleted:
+				if err := msg.Ack(false); err != nil {
+					report(ctx, msg, errors.Join(NewAckFailedError(msg), err))
+				}
+				return
+			case IdempotencyInProgress:
+				if err := msg.Nack(false, true); err != nil {
+					report(ctx, msg, errors.Join(NewNackFailedError(msg), err))
+				}
+				return
+			}
+
+			// the message may be settled after the handler context is done
+			hookCtx := context.WithoutCancel(ctx)
+			msg = withAckHooks(msg, func() {
+				if err := store.Complete(hookCtx, key); err != nil {
+					report(hookCtx, msg, err)
+				}
+			}, func(bool) {
+				if err := store.Release(hookCtx, key); err != nil {
+					report(hookCtx, msg, err)
+				}
+			})
+
+			next.Consume(ctx, msg)
+		})
+	}
+}
+
+// acquireOrWait polls a key in progress until it's settled by the other delivery, its lease expires
+// or ctx is done, in the latter case the key is still in progress
+func acquireOrWait(ctx context.Context, store IdempotencyStore, key string, lease time.Duration) (IdempotencyState, error) {
+	poll := minIdempotencyPoll
+	for {
+		state, err := store.Acquire(ctx, key, lease)
+		if err != nil || state != IdempotencyInProgress {
+			return state, err
+		}
+
+		timer := time.NewTimer(poll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return state, nil
+		case <-timer.C:
+		}
+		poll = min(poll*2, maxIdempotencyPoll, lease)
+	}
+}
+
+var (
+	_ IdempotencyStore = (*MemoryIdempotencyStore)(nil)
+	_ IdempotencyStore = (*SQLIdempotencyStore)(nil)
+)
+
+// MemoryIdempotencyStore keeps up to capacity keys in memory, the least recently used ones are evicted first.
+// It only deduplicates deliveries of the same process, use SQLIdempotencyStore for several replicas.
+type MemoryIdempotencyStore struct {
+	capacity int
+	ttl      time.Duration
+
+	entries map[string]*list.Element
+	// the most recently used entries in front
+	lru *list.List
+	mx  sync.Mutex
+}
+
+type idempotencyEntry struct {
+	key       string
+	completed bool
+	expiresAt time.Time
+}
+
+// NewMemoryIdempotencyStore keeps completed keys for ttl, zero ttl keeps them until evicted
+func NewMemoryIdempotencyStore(capacity int, ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		capacity: max(capacity, 1),
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Acquire(_ context.Context, key string, lease time.Duration) (IdempotencyState, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*idempotencyEntry)
+		if entry.expiresAt.IsZero() || now.Before(entry.expiresAt) {
+			s.lru.MoveToFront(element)
+			if entry.completed {
+				return IdempotencyCompleted, nil
+			}
+			return IdempotencyInProgress, nil
+		}
+		s.remove(element)
+	}
+
+	s.entries[key] = s.lru.PushFront(&idempotencyEntry{key: key, expiresAt: now.Add(lease)})
+	for s.lru.Len() > s.capacity {
+		s.remove(s.lru.Back())
+	}
+	return IdempotencyAcquired, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(_ context.Context, key string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		// evicted meanwhile
+		element = s.lru.PushFront(&idempotencyEntry{key: key})
+		s.entries[key] = element
+		for s.lru.Len() > s.capacity {
+			s.remove(s.lru.Back())
+		}
+	}
+
+	entry := element.Value.(*idempotencyEntry)
+	entry.completed = true
+	entry.expiresAt = time.Time{}
+	if s.ttl > 0 {
+		entry.expiresAt = time.Now().Add(s.ttl)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if element, ok := s.entries[key]; ok && !element.Value.(*idempotencyEntry).completed {
+		s.remove(element)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) remove(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*idempotencyEntry).key)
+}
+
+const (
+	idempotencyInProgress = "in_progress"
+	idempotencyCompleted  = "completed"
+)
+
+// SQLIdempotencyStore keeps keys in a database table, shared by all replicas of a consumer
+type SQLIdempotencyStore struct {
+	db    *sql.DB
+	table string
+	ph    SQLPlaceholder
+	ttl   time.Duration
+}
+
+// NewSQLIdempotencyStore keeps completed keys for ttl, expired keys are removed by Cleanup.
+// Zero ttl keeps completed keys until they are removed by hand.
+func NewSQLIdempotencyStore(db *sql.DB, table string, placeholder SQLPlaceholder, ttl time.Duration) *SQLIdempotencyStore {
+	if table == "" {
+		table = "idempotency_keys"
+	}
+	return &SQLIdempotencyStore{db: db, table: table, ph: placeholder, ttl: ttl}
+}
+
+// CreateSchema creates keys table unless it exists, expires_at of keys which never expire is NULL
+func (s *SQLIdempotencyStore) CreateSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	idempotency_key VARCHAR(255) NOT NULL PRIMARY KEY,
+	status          VARCHAR(16) NOT NULL,
+	expires_at      TIMESTAMP NULL
+)`, s.table))
+	return err
+}
+
+// Acquire inserts the key, and when it exists takes over an expired one.
+// The key is looked up after any failed insert, not only after a duplicate one.
+func (s *SQLIdempotencyStore) Acquire(ctx context.Context, key string, lease time.Duration) (IdempotencyState, error) {
+	now := time.Now().UTC()
+
+	insert := fmt.Sprintf("INSERT INTO %s (idempotency_key, status, expires_at) VALUES (%s, %s, %s)",
+		s.table, s.ph(1), s.ph(2), s.ph(3))
+	_, insertErr := s.db.ExecContext(ctx, insert, key, idempotencyInProgress, now.Add(lease))
+	if insertErr == nil {
+		return IdempotencyAcquired, nil
+	}
+
+	query := fmt.Sprintf("SELECT status, expires_at FROM %s WHERE idempotency_key = %s", s.table, s.ph(1))
+	var status string
+	var expiresAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&status, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, insertErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !expiresAt.Valid || now.Before(expiresAt.Time) {
+		if status == idempotencyCompleted {
+			return IdempotencyCompleted, nil
+		}
+		return IdempotencyInProgress, nil
+	}
+
+	// expiration is checked once more, so that only one of concurrent callers takes the key over
+	update := fmt.Sprintf("UPDATE %s SET status = %s, expires_at = %s WHERE idempotency_key = %s AND expires_at = %s",
+		s.table, s.ph(1), s.ph(2), s.ph(3), s.ph(4))
+	res, err := s.db.ExecContext(ctx, update, idempotencyInProgress, now.Add(lease), key, expiresAt.Time)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return IdempotencyInProgress, nil
+	}
+	return IdempotencyAcquired, nil
+}
+
+func (s *SQLIdempotencyStore) Complete(ctx context.Context, key string) error {
+	// NULL keeps the key until it's removed by hand
+	var expiresAt sql.NullTime
+	if s.ttl > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().Add(s.ttl), Valid: true}
+	}
+
+	update := fmt.Sprintf("UPDATE %s SET status = %s, expires_at = %s WHERE idempotency_key = %s",
+		s.table, s.ph(1), s.ph(2), s.ph(3))
+	_, err := s.db.ExecContext(ctx, update, idempotencyCompleted, expiresAt, key)
+	return err
+}
+
+func (s *SQLIdempotencyStore) Release(ctx context.Context, key string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE idempotency_key = %s AND status = %s", s.table, s.ph(1), s.ph(2))
+	_, err := s.db.ExecContext(ctx, query, key, idempotencyInProgress)
+	return err
+}
+
+// Cleanup removes expired keys, call it periodically
+func (s *SQLIdempotencyStore) Cleanup(ctx context.Context) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE expires_at < %s", s.table, s.ph(1))
+	_, err := s.db.ExecContext(ctx, query, time.Now().UTC())
+	return err
+}
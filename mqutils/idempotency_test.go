@@ -0,0 +1,209 @@
+package mqutils
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+func TestIdempotencyCustomKeyHandlesRepublishedCopies(t *testing.T) {
+	store := NewMemoryIdempotencyStore(10, 0)
+	cfg := IdempotencyConfig{Key: func(msg amqp.Delivery) string { return msg.CorrelationId }}
+
+	var handled int
+	consumer := NewIdempotencyMiddleware(store, cfg, nil)(rabbitmq.ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+		handled++
+		_ = msg.Ack(false)
+	}))
+
+	original := amqp.Delivery{Acknowledger: &recordingAcknowledger{}, CorrelationId: "order-1"}
+	retried := original
+	retried.Acknowledger = &recordingAcknowledger{}
+	retried.Headers = amqp.Table{retryAttemptHeader: int64(1)}
+	duplicate := original
+	duplicate.Acknowledger = &recordingAcknowledger{}
+
+	for _, msg := range []amqp.Delivery{original, retried, duplicate} {
+		consumer.Consume(context.Background(), msg)
+	}
+
+	if handled != 2 {
+		t.Errorf("handled %d deliveries, want the original and its retried copy", handled)
+	}
+	if calls := duplicate.Acknowledger.(*recordingAcknowledger).calls; !reflect.DeepEqual(calls, []string{"ack"}) {
+		t.Errorf("duplicate settled %q, want skipped with ack", calls)
+	}
+}
+
+func TestIdempotencyDuplicateWaitsForDeliveryInProgress(t *testing.T) {
+	store := NewMemoryIdempotencyStore(10, 0)
+
+	release := make(chan struct{})
+	handled := make(chan string, 2)
+	consumer := NewIdempotencyMiddleware(store, IdempotencyConfig{}, nil)(rabbitmq.ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+		handled <- msg.MessageId
+		<-release
+		_ = msg.Ack(false)
+	}))
+
+	first := amqp.Delivery{Acknowledger: &recordingAcknowledger{}, MessageId: "m1"}
+	duplicate := amqp.Delivery{Acknowledger: &recordingAcknowledger{}, MessageId: "m1"}
+
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		consumer.Consume(context.Background(), first)
+	}()
+	<-handled
+
+	duplicateDone := make(chan struct{})
+	go func() {
+		defer close(duplicateDone)
+		consumer.Consume(context.Background(), duplicate)
+	}()
+
+	select {
+	case <-duplicateDone:
+		t.Fatal("duplicate was settled while the first delivery is in progress")
+	case <-time.After(3 * minIdempotencyPoll):
+	}
+
+	close(release)
+	<-firstDone
+	<-duplicateDone
+
+	if len(handled) != 0 {
+		t.Error("duplicate was handled")
+	}
+	if calls := duplicate.Acknowledger.(*recordingAcknowledger).calls; !reflect.DeepEqual(calls, []string{"ack"}) {
+		t.Errorf("duplicate settled %q, want skipped with ack", calls)
+	}
+}
+
+func TestIdempotencyDuplicateIsRequeuedWhenContextIsDone(t *testing.T) {
+	store := NewMemoryIdempotencyStore(10, 0)
+	if _, err := store.Acquire(context.Background(), copyKey("m1", amqp.Delivery{}), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer := NewIdempotencyMiddleware(store, IdempotencyConfig{}, nil)(rabbitmq.ConsumerFunc(func(context.Context, amqp.Delivery) {
+		t.Error("duplicate was handled")
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*minIdempotencyPoll)
+	defer cancel()
+	duplicate := amqp.Delivery{Acknowledger: &recordingAcknowledger{}, MessageId: "m1"}
+	consumer.Consume(ctx, duplicate)
+
+	if calls := duplicate.Acknowledger.(*recordingAcknowledger).calls; !reflect.DeepEqual(calls, []string{"nack requeue=true"}) {
+		t.Errorf("duplicate settled %q, want requeued", calls)
+	}
+}
+
+func newTestSQLIdempotencyStore(t *testing.T, ttl time.Duration) (*sql.DB, *SQLIdempotencyStore) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection has its own in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	store := NewSQLIdempotencyStore(db, "", QuestionPlaceholder, ttl)
+	if err := store.CreateSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db, store
+}
+
+func acquire(t *testing.T, store IdempotencyStore, key string, lease time.Duration, want IdempotencyState) {
+	t.Helper()
+
+	state, err := store.Acquire(context.Background(), key, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != want {
+		t.Errorf("Acquire(%q) = %d, want %d", key, state, want)
+	}
+}
+
+func TestSQLIdempotencyStoreClaimAndComplete(t *testing.T) {
+	_, store := newTestSQLIdempotencyStore(t, 0)
+	ctx := context.Background()
+
+	acquire(t, store, "k1", time.Minute, IdempotencyAcquired)
+	acquire(t, store, "k1", time.Minute, IdempotencyInProgress)
+
+	if err := store.Complete(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	acquire(t, store, "k1", time.Minute, IdempotencyCompleted)
+
+	// completed keys without ttl never expire
+	if err := store.Cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	acquire(t, store, "k1", time.Minute, IdempotencyCompleted)
+}
+
+func TestSQLIdempotencyStoreRelease(t *testing.T) {
+	_, store := newTestSQLIdempotencyStore(t, 0)
+	ctx := context.Background()
+
+	acquire(t, store, "k1", time.Minute, IdempotencyAcquired)
+	if err := store.Release(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	acquire(t, store, "k1", time.Minute, IdempotencyAcquired)
+
+	// completed keys are not released
+	if err := store.Complete(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Release(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	acquire(t, store, "k1", time.Minute, IdempotencyCompleted)
+}
+
+func TestSQLIdempotencyStoreExpiry(t *testing.T) {
+	db, store := newTestSQLIdempotencyStore(t, time.Hour)
+	ctx := context.Background()
+
+	// an expired lease is taken over, e.g. after a crash of the process handling the message
+	acquire(t, store, "crashed", time.Nanosecond, IdempotencyAcquired)
+	time.Sleep(time.Millisecond)
+	acquire(t, store, "crashed", time.Minute, IdempotencyAcquired)
+	acquire(t, store, "crashed", time.Minute, IdempotencyInProgress)
+
+	acquire(t, store, "old", time.Minute, IdempotencyAcquired)
+	if err := store.Complete(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE idempotency_keys SET expires_at = ? WHERE idempotency_key = 'old'",
+		time.Now().UTC().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM idempotency_keys").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Errorf("%d keys left, want the one in progress", left)
+	}
+	acquire(t, store, "old", time.Minute, IdempotencyAcquired)
+}
@@ -0,0 +1,126 @@
+// Package inbox implements transactional inbox: id of a handled message is recorded into a database table
+// in the same transaction as business data, and the message is acknowledged only after the commit.
+// Thus, effects of a message are committed exactly once, while redeliveries of it are skipped.
+//
+// Usage with Router:
+//
+//	in := inbox.New(db, inbox.DefaultTable, mqutils.DollarPlaceholder)
+//	router.RegisterEventHandler("order.created", in.Handler(func(ctx context.Context, msg amqp.Delivery) error {
+//		tx, _ := inbox.TxFromContext(ctx)
+//		_, err := tx.ExecContext(ctx, "INSERT INTO orders ...")
+//		return err
+//	}))
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq/mqutils"
+)
+
+const DefaultTable = "inbox"
+
+// ErrMissingMessageID is wrapped into mqutils.DeadLetter outcome for messages without MessageId,
+// which can't be deduplicated, so that Router rejects them instead of requeueing forever
+var ErrMissingMessageID = errors.New("message has no id")
+
+type txKey struct{}
+
+// TxFromContext returns transaction of the message handled by Inbox.Handler
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+type Inbox struct {
+	db    *sql.DB
+	table string
+	ph    mqutils.SQLPlaceholder
+}
+
+func New(db *sql.DB, table string, placeholder mqutils.SQLPlaceholder) *Inbox {
+	if table == "" {
+		table = DefaultTable
+	}
+	return &Inbox{db: db, table: table, ph: placeholder}
+}
+
+// CreateSchema creates inbox table unless it exists
+func (i *Inbox) CreateSchema(ctx context.Context) error {
+	_, err := i.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	message_id  VARCHAR(255) NOT NULL PRIMARY KEY,
+	routing_key VARCHAR(255) NOT NULL,
+	received_at TIMESTAMP NOT NULL
+)`, i.table))
+	return err
+}
+
+// Handler runs handler within a transaction, which is available via TxFromContext.
+//
+// Message id is inserted into the inbox table first, so a concurrent duplicate waits on the primary key
+// until the transaction ends (or fails right away, depending on the database). The transaction is committed
+// when handler returns nil, and Router acknowledges the message afterwards. Any error, including
+// mqutils.Outcome, rolls the transaction back. Messages whose id is already committed are skipped,
+// i.e. acknowledged without calling handler.
+func (i *Inbox) Handler(handler mqutils.Handler) mqutils.Handler {
+	return func(ctx context.Context, msg amqp.Delivery) error {
+		if msg.MessageId == "" {
+			return mqutils.DeadLetter("missing message id").WithErr(ErrMissingMessageID)
+		}
+
+		tx, err := i.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		insert := fmt.Sprintf("INSERT INTO %s (message_id, routing_key, received_at) VALUES (%s, %s, %s)",
+			i.table, i.ph(1), i.ph(2), i.ph(3))
+		if _, insertErr := tx.ExecContext(ctx, insert, msg.MessageId, msg.RoutingKey, time.Now().UTC()); insertErr != nil {
+			_ = tx.Rollback()
+
+			handled, err := i.Handled(ctx, msg.MessageId)
+			if err != nil {
+				return errors.Join(insertErr, err)
+			}
+			if handled {
+				return nil
+			}
+			return insertErr
+		}
+
+		if err := handler(context.WithValue(ctx, txKey{}, tx), msg); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return errors.Join(err, rollbackErr)
+			}
+			return err
+		}
+
+		return tx.Commit()
+	}
+}
+
+// Handled tells whether the message with the id was handled and committed
+func (i *Inbox) Handled(ctx context.Context, messageID string) (bool, error) {
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE message_id = %s", i.table, i.ph(1))
+
+	var one int
+	err := i.db.QueryRowContext(ctx, query, messageID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Cleanup removes ids received earlier than retention ago, redeliveries older than that are not expected.
+// Call it periodically.
+func (i *Inbox) Cleanup(ctx context.Context, retention time.Duration) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE received_at < %s", i.table, i.ph(1))
+	_, err := i.db.ExecContext(ctx, query, time.Now().UTC().Add(-retention))
+	return err
+}
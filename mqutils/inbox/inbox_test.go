@@ -0,0 +1,162 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq/mqutils"
+)
+
+func newTestInbox(t *testing.T) (*sql.DB, *Inbox) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection has its own in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	in := New(db, "", mqutils.QuestionPlaceholder)
+	if err := in.CreateSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE orders (id VARCHAR(255) NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	return db, in
+}
+
+// insertOrder is a handler storing business data within the inbox transaction
+func insertOrder(ctx context.Context, msg amqp.Delivery) error {
+	tx, ok := TxFromContext(ctx)
+	if !ok {
+		return errors.New("no transaction in context")
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO orders (id) VALUES (?)", string(msg.Body))
+	return err
+}
+
+func countOrders(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestHandlerSkipsDuplicates(t *testing.T) {
+	db, in := newTestInbox(t)
+
+	var calls int
+	handler := in.Handler(func(ctx context.Context, msg amqp.Delivery) error {
+		calls++
+		return insertOrder(ctx, msg)
+	})
+
+	msg := amqp.Delivery{MessageId: "m1", RoutingKey: "order.created", Body: []byte("order-1")}
+	for i := 0; i < 2; i++ {
+		if err := handler(context.Background(), msg); err != nil {
+			t.Fatalf("delivery %d: %v", i+1, err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+	if n := countOrders(t, db); n != 1 {
+		t.Errorf("%d orders stored, want 1", n)
+	}
+}
+
+func TestHandlerRollsBackOnError(t *testing.T) {
+	db, in := newTestInbox(t)
+
+	failure := errors.New("downstream is unavailable")
+	handler := in.Handler(func(ctx context.Context, msg amqp.Delivery) error {
+		if err := insertOrder(ctx, msg); err != nil {
+			return err
+		}
+		return failure
+	})
+
+	msg := amqp.Delivery{MessageId: "m1", RoutingKey: "order.created", Body: []byte("order-1")}
+	if err := handler(context.Background(), msg); !errors.Is(err, failure) {
+		t.Fatalf("handler error = %v, want %v", err, failure)
+	}
+
+	if n := countOrders(t, db); n != 0 {
+		t.Errorf("%d orders stored, want the insert rolled back", n)
+	}
+	if handled, err := in.Handled(context.Background(), "m1"); err != nil || handled {
+		t.Errorf("Handled() = %t, %v, want the redelivery handled again", handled, err)
+	}
+}
+
+func TestHandlerRejectsMessageWithoutID(t *testing.T) {
+	_, in := newTestInbox(t)
+
+	err := in.Handler(insertOrder)(context.Background(), amqp.Delivery{Body: []byte("order-1")})
+
+	var outcome *mqutils.Outcome
+	if !errors.As(err, &outcome) || !errors.Is(err, ErrMissingMessageID) {
+		t.Errorf("handler error = %v, want dead letter outcome", err)
+	}
+}
+
+// committedAcknowledger checks that the message is committed into the inbox by the time it's acknowledged
+type committedAcknowledger struct {
+	t     *testing.T
+	inbox *Inbox
+	id    string
+	acked bool
+}
+
+func (a *committedAcknowledger) Ack(_ uint64, _ bool) error {
+	handled, err := a.inbox.Handled(context.Background(), a.id)
+	if err != nil || !handled {
+		a.t.Errorf("acknowledged before commit: Handled() = %t, %v", handled, err)
+	}
+	a.acked = true
+	return nil
+}
+
+func (a *committedAcknowledger) Nack(_ uint64, _, _ bool) error {
+	a.t.Error("message was nacked")
+	return nil
+}
+
+func (a *committedAcknowledger) Reject(_ uint64, _ bool) error {
+	a.t.Error("message was rejected")
+	return nil
+}
+
+func TestRouterAcknowledgesAfterCommit(t *testing.T) {
+	_, in := newTestInbox(t)
+
+	router := mqutils.NewRouter(func(_ context.Context, _ amqp.Delivery, err error) bool {
+		t.Errorf("unexpected error: %v", err)
+		return false
+	})
+	router.RegisterEventHandler("order.created", in.Handler(insertOrder))
+
+	ack := &committedAcknowledger{t: t, inbox: in, id: "m1"}
+	router.Consume(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		MessageId:    "m1",
+		RoutingKey:   "order.created",
+		Body:         []byte("order-1"),
+	})
+
+	if !ack.acked {
+		t.Error("message was not acknowledged")
+	}
+}
@@ -81,18 +81,24 @@ func NewConsumerTraceLoggerMid() Middleware {
 	}
 }
 
-// NewOpenTelemetryMiddleware openTelemetry tracer for rabbitmq
+// NewOpenTelemetryMiddleware openTelemetry tracer for rabbitmq.
+// It reads both standard `traceparent` headers (see PublishWithOpenTelemetry) and legacy ones written by Publish.
 func NewOpenTelemetryMiddleware() Middleware {
 	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
 		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
 			var span trace2.Span
 			var tracerCtx context.Context
 
-			tracer := otel.Tracer("openTelemetry-amqp-tracer")
+			tracer := otel.Tracer(openTelemetryTracerName)
 
 			spanName := fmt.Sprintf("|consume|%s|%s", msg.Exchange, msg.RoutingKey)
 			bagItemsJson, ok := msg.Headers[opentracingData].(string)
-			if ok {
+			if _, hasTraceparent := msg.Headers["traceparent"]; hasTraceparent {
+				// Extract context with the globally configured propagator
+				parentCtx := otel.GetTextMapPropagator().Extract(ctx, HeadersCarrier(msg.Headers))
+
+				tracerCtx, span = tracer.Start(parentCtx, spanName, trace2.WithSpanKind(trace2.SpanKindConsumer))
+			} else if ok {
 				bagItems := map[string]string{}
 				_ = json.Unmarshal([]byte(bagItemsJson), &bagItems)
 
@@ -110,6 +116,7 @@ func NewOpenTelemetryMiddleware() Middleware {
 
 			// Add attributes instead of log fields
 			span.SetAttributes(attribute.String("message_id", msg.MessageId))
+			span.SetAttributes(consumerSpanAttributes(msg)...)
 
 			// Consume the message
 			next.Consume(tracerCtx, msg)
@@ -120,7 +127,7 @@ func NewOpenTelemetryMiddleware() Middleware {
 func NewConsumerOpenTelemetryTraceLoggerMid() Middleware {
 	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
 		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
-			tracer := otel.Tracer("openTelemetry-amqp-tracer") // Create tracer
+			tracer := otel.Tracer(openTelemetryTracerName) // Create tracer
 
 			// Start a new span from the context
 			newCtx, span := tracer.Start(ctx, "LOG_MESSAGE", trace2.WithSpanKind(trace2.SpanKindConsumer))
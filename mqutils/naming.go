@@ -18,3 +18,17 @@ func NewExchangeName(appName, exchangeName string) string {
 func NewQueueName(appName, appEnv, queueName string) string {
 	return fmt.Sprintf("%s.%s.%s", appName, appEnv, queueName)
 }
+
+// NewDeadLetterExchangeName placeholder in format: "{queue}.dlx"
+// example result: "subscriptions.prod.primary.dlx"
+// it's the name rabbitmq.DeadLetterParams use by default
+func NewDeadLetterExchangeName(queueName string) string {
+	return fmt.Sprintf("%s.dlx", queueName)
+}
+
+// NewDeadLetterQueueName placeholder in format: "{queue}.dlq"
+// example result: "subscriptions.prod.primary.dlq"
+// it's the name rabbitmq.DeadLetterParams use by default
+func NewDeadLetterQueueName(queueName string) string {
+	return fmt.Sprintf("%s.dlq", queueName)
+}
@@ -0,0 +1,136 @@
+package mqutils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+var (
+	_ rabbitmq.OffsetStore = (*FileOffsetStore)(nil)
+	_ rabbitmq.OffsetStore = (*SQLOffsetStore)(nil)
+)
+
+// FileOffsetStore keeps offsets of stream consumers in a json file
+type FileOffsetStore struct {
+	path    string
+	offsets map[string]int64
+	mx      sync.Mutex
+}
+
+func NewFileOffsetStore(path string) (*FileOffsetStore, error) {
+	s := &FileOffsetStore{path: path, offsets: make(map[string]int64)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.offsets); err != nil {
+		return nil, fmt.Errorf("reading offsets from %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileOffsetStore) Load(_ context.Context, consumerID string) (int64, bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	offset, ok := s.offsets[consumerID]
+	return offset, ok, nil
+}
+
+func (s *FileOffsetStore) Save(_ context.Context, consumerID string, offset int64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.offsets[consumerID] = offset
+	data, err := json.Marshal(s.offsets)
+	if err != nil {
+		return err
+	}
+
+	// replace the file atomically, so that a crash never leaves it half written
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// SQLOffsetStore keeps offsets of stream consumers in a database table
+type SQLOffsetStore struct {
+	db    *sql.DB
+	table string
+	ph    SQLPlaceholder
+}
+
+func NewSQLOffsetStore(db *sql.DB, table string, placeholder SQLPlaceholder) *SQLOffsetStore {
+	if table == "" {
+		table = "stream_offsets"
+	}
+	return &SQLOffsetStore{db: db, table: table, ph: placeholder}
+}
+
+// CreateSchema creates offsets table unless it exists
+func (s *SQLOffsetStore) CreateSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	consumer_id   VARCHAR(255) NOT NULL PRIMARY KEY,
+	stream_offset BIGINT NOT NULL,
+	updated_at    TIMESTAMP NOT NULL
+)`, s.table))
+	return err
+}
+
+func (s *SQLOffsetStore) Load(ctx context.Context, consumerID string) (int64, bool, error) {
+	query := fmt.Sprintf("SELECT stream_offset FROM %s WHERE consumer_id = %s", s.table, s.ph(1))
+
+	var offset int64
+	err := s.db.QueryRowContext(ctx, query, consumerID).Scan(&offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return offset, true, nil
+}
+
+// Save updates the offset or inserts it, upsert syntax differs between databases so it's avoided
+func (s *SQLOffsetStore) Save(ctx context.Context, consumerID string, offset int64) error {
+	now := time.Now().UTC()
+
+	update := fmt.Sprintf("UPDATE %s SET stream_offset = %s, updated_at = %s WHERE consumer_id = %s",
+		s.table, s.ph(1), s.ph(2), s.ph(3))
+	res, err := s.db.ExecContext(ctx, update, offset, now, consumerID)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected > 0 {
+		return err
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (consumer_id, stream_offset, updated_at) VALUES (%s, %s, %s)",
+		s.table, s.ph(1), s.ph(2), s.ph(3))
+	_, err = s.db.ExecContext(ctx, insert, consumerID, offset, now)
+	return err
+}
@@ -0,0 +1,93 @@
+package mqutils
+
+import (
+	"context"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	trace2 "go.opentelemetry.io/otel/trace"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+const openTelemetryTracerName = "openTelemetry-amqp-tracer"
+
+// HeadersCarrier adapts message headers to propagation.TextMapCarrier,
+// so that standard `traceparent`, `tracestate` and `baggage` headers can be injected and extracted
+type HeadersCarrier amqp.Table
+
+var _ propagation.TextMapCarrier = HeadersCarrier{}
+
+func (c HeadersCarrier) Get(key string) string {
+	switch v := c[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+func (c HeadersCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c HeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// PublishWithOpenTelemetry publishes message within a producer span and injects its context into headers
+// by globally configured propagator (see otel.SetTextMapPropagator), which any OpenTelemetry SDK can read
+func PublishWithOpenTelemetry(ctx context.Context, exchange, key string, msg amqp.Publishing, client *rabbitmq.Client) error {
+	tracer := otel.Tracer(openTelemetryTracerName)
+
+	spanName := fmt.Sprintf("|publish|%s|%s", exchange, key)
+	newCtx, span := tracer.Start(ctx, spanName,
+		trace2.WithSpanKind(trace2.SpanKindProducer),
+		trace2.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingOperationName("publish"),
+			semconv.MessagingDestinationName(exchange),
+			semconv.MessagingRabbitmqDestinationRoutingKey(key),
+			semconv.MessagingMessageID(msg.MessageId),
+			semconv.MessagingMessageConversationID(msg.CorrelationId),
+			semconv.MessagingMessageBodySize(len(msg.Body)),
+		),
+	)
+	defer span.End()
+
+	msg.Headers = copyHeaders(msg.Headers)
+	otel.GetTextMapPropagator().Inject(newCtx, HeadersCarrier(msg.Headers))
+
+	if err := client.Publish(newCtx, exchange, key, false, false, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// consumerSpanAttributes describes a delivery in terms of messaging semantic conventions
+func consumerSpanAttributes(msg amqp.Delivery) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemRabbitmq,
+		semconv.MessagingOperationTypeDeliver,
+		semconv.MessagingOperationName("consume"),
+		semconv.MessagingDestinationName(msg.Exchange),
+		semconv.MessagingRabbitmqDestinationRoutingKey(msg.RoutingKey),
+		semconv.MessagingMessageID(msg.MessageId),
+		semconv.MessagingMessageConversationID(msg.CorrelationId),
+		semconv.MessagingMessageBodySize(len(msg.Body)),
+	}
+}
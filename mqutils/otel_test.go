@@ -0,0 +1,55 @@
+package mqutils
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	trace2 "go.opentelemetry.io/otel/trace"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+func TestOpenTelemetryPropagation(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	traceID, _ := trace2.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace2.SpanIDFromHex("00f067aa0ba902b7")
+	publisherCtx := trace2.ContextWithSpanContext(context.Background(), trace2.NewSpanContext(trace2.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace2.FlagsSampled,
+	}))
+
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(publisherCtx, HeadersCarrier(headers))
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatalf("injected headers %v, want traceparent", headers)
+	}
+
+	var consumed trace2.SpanContext
+	consumer := NewOpenTelemetryMiddleware()(rabbitmq.ConsumerFunc(func(ctx context.Context, _ amqp.Delivery) {
+		consumed = trace2.SpanContextFromContext(ctx)
+	}))
+
+	// headers may arrive as byte arrays, depending on the publisher
+	for name, value := range map[string]any{"string": headers["traceparent"], "bytes": []byte(headers["traceparent"].(string))} {
+		consumed = trace2.SpanContext{}
+		consumer.Consume(context.Background(), amqp.Delivery{Headers: amqp.Table{"traceparent": value}})
+
+		if consumed.TraceID() != traceID {
+			t.Errorf("%s: consumed within trace %s, want %s", name, consumed.TraceID(), traceID)
+		}
+	}
+
+	// without trace headers the consumer isn't a part of any trace
+	consumed = trace2.SpanContext{}
+	consumer.Consume(context.Background(), amqp.Delivery{})
+	if consumed.TraceID() == traceID {
+		t.Error("message without trace headers was consumed within the publisher trace")
+	}
+}
@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alifcapital/rabbitmq/mqutils"
+)
+
+// Dialect holds database specific parts of queries
+type Dialect struct {
+	Name        string
+	Placeholder mqutils.SQLPlaceholder
+	// LockClause is appended to select of pending rows, so that several relays don't publish the same rows
+	LockClause string
+	// schema is a CREATE TABLE template with a single %s for the table name
+	schema string
+}
+
+var Postgres = Dialect{
+	Name:        "postgres",
+	Placeholder: mqutils.DollarPlaceholder,
+	LockClause:  "FOR UPDATE SKIP LOCKED",
+	schema: `CREATE TABLE IF NOT EXISTS %[1]s (
+	id           BIGSERIAL PRIMARY KEY,
+	aggregate_id TEXT NOT NULL DEFAULT '',
+	exchange     TEXT NOT NULL,
+	routing_key  TEXT NOT NULL,
+	properties   TEXT NOT NULL,
+	body         BYTEA,
+	created_at   TIMESTAMPTZ NOT NULL,
+	sent_at      TIMESTAMPTZ NULL
+);
+CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (aggregate_id, id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS %[1]s_sent_at_idx ON %[1]s (sent_at);`,
+}
+
+// MySQL requires 8.0+ for SKIP LOCKED
+var MySQL = Dialect{
+	Name:        "mysql",
+	Placeholder: mqutils.QuestionPlaceholder,
+	LockClause:  "FOR UPDATE SKIP LOCKED",
+	schema: `CREATE TABLE IF NOT EXISTS %[1]s (
+	id           BIGINT AUTO_INCREMENT PRIMARY KEY,
+	aggregate_id VARCHAR(255) NOT NULL DEFAULT '',
+	exchange     VARCHAR(255) NOT NULL,
+	routing_key  VARCHAR(255) NOT NULL,
+	properties   TEXT NOT NULL,
+	body         LONGBLOB,
+	created_at   DATETIME(6) NOT NULL,
+	sent_at      DATETIME(6) NULL,
+	INDEX %[1]s_pending_idx (sent_at, aggregate_id, id),
+	INDEX %[1]s_sent_at_idx (sent_at)
+);`,
+}
+
+// SQLite has no row locks, whole database is locked by a writing transaction instead
+var SQLite = Dialect{
+	Name:        "sqlite",
+	Placeholder: mqutils.QuestionPlaceholder,
+	schema: `CREATE TABLE IF NOT EXISTS %[1]s (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	aggregate_id TEXT NOT NULL DEFAULT '',
+	exchange     TEXT NOT NULL,
+	routing_key  TEXT NOT NULL,
+	properties   TEXT NOT NULL,
+	body         BLOB,
+	created_at   TIMESTAMP NOT NULL,
+	sent_at      TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (sent_at, aggregate_id, id);`,
+}
+
+// Schema returns statements creating outbox table, some drivers require executing them one by one
+func (d Dialect) Schema(table string) []string {
+	var statements []string
+	for _, statement := range strings.Split(fmt.Sprintf(d.schema, table), ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
@@ -0,0 +1,163 @@
+// Package outbox implements transactional outbox: messages are written into a database table
+// in the same transaction as business data, and a Relay publishes them afterwards.
+// Thus, a message is published if and only if the transaction was committed (at-least-once).
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const DefaultTable = "outbox"
+
+// Message is a publishing waiting in outbox
+type Message struct {
+	Exchange   string
+	RoutingKey string
+	// messages of the same aggregate are published strictly in the order they were stored,
+	// messages with empty AggregateID are published in any order
+	AggregateID string
+	// headers are kept as json, so byte slices come back as base64 strings and numbers as int64 or float64
+	Publishing amqp.Publishing
+}
+
+type Outbox struct {
+	table   string
+	dialect Dialect
+}
+
+func New(table string, dialect Dialect) *Outbox {
+	if table == "" {
+		table = DefaultTable
+	}
+	return &Outbox{table: table, dialect: dialect}
+}
+
+// CreateSchema creates outbox table unless it exists
+func (o *Outbox) CreateSchema(ctx context.Context, db *sql.DB) error {
+	for _, statement := range o.dialect.Schema(o.table) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store writes message into outbox within caller's transaction
+func (o *Outbox) Store(ctx context.Context, tx *sql.Tx, msg Message) error {
+	properties, err := encodeProperties(msg.Publishing)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO %s (aggregate_id, exchange, routing_key, properties, body, created_at) VALUES (%s, %s, %s, %s, %s, %s)",
+		o.table, o.ph(1), o.ph(2), o.ph(3), o.ph(4), o.ph(5), o.ph(6),
+	)
+	_, err = tx.ExecContext(ctx, query,
+		msg.AggregateID, msg.Exchange, msg.RoutingKey, properties, msg.Publishing.Body, time.Now().UTC(),
+	)
+	return err
+}
+
+func (o *Outbox) ph(n int) string {
+	return o.dialect.Placeholder(n)
+}
+
+// properties is a serializable form of amqp.Publishing without body
+type properties struct {
+	Headers         amqp.Table `json:"headers,omitempty"`
+	ContentType     string     `json:"content_type,omitempty"`
+	ContentEncoding string     `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8      `json:"delivery_mode,omitempty"`
+	Priority        uint8      `json:"priority,omitempty"`
+	CorrelationId   string     `json:"correlation_id,omitempty"`
+	ReplyTo         string     `json:"reply_to,omitempty"`
+	Expiration      string     `json:"expiration,omitempty"`
+	MessageId       string     `json:"message_id,omitempty"`
+	Timestamp       time.Time  `json:"timestamp,omitempty"`
+	Type            string     `json:"type,omitempty"`
+	UserId          string     `json:"user_id,omitempty"`
+	AppId           string     `json:"app_id,omitempty"`
+}
+
+func encodeProperties(msg amqp.Publishing) (string, error) {
+	b, err := json.Marshal(properties{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+	})
+	return string(b), err
+}
+
+func decodeProperties(data string, body []byte) (amqp.Publishing, error) {
+	var p properties
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&p); err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	headers := amqp.Table{}
+	for k, v := range p.Headers {
+		headers[k] = restoreNumbers(v)
+	}
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		Body:            body,
+	}, nil
+}
+
+// restoreNumbers converts json numbers back into types amqp.Table accepts
+func restoreNumbers(v any) any {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]any:
+		table := amqp.Table{}
+		for k, item := range value {
+			table[k] = restoreNumbers(item)
+		}
+		return table
+	case []any:
+		for i, item := range value {
+			value[i] = restoreNumbers(item)
+		}
+		return value
+	default:
+		return v
+	}
+}
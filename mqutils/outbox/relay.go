@@ -0,0 +1,232 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+	"github.com/alifcapital/rabbitmq/mqutils"
+)
+
+type RelayConfig struct {
+	// max number of rows published per transaction
+	BatchSize int
+	// pause between polls when outbox has nothing to publish
+	PollInterval time.Duration
+	// sent rows older than Retention are deleted every CleanupInterval, zero Retention disables cleanup
+	Retention       time.Duration
+	CleanupInterval time.Duration
+	// this callback will be invoked whenever polling, publishing or cleanup fails
+	ErrCallback func(error)
+}
+
+// Relay publishes pending outbox rows. Any number of relay replicas may run against the same table:
+// rows are locked while being published and rows locked by other replicas are skipped.
+//
+// Client must have PublisherConfirmEnabled, so that a row is marked as sent only after broker confirmed it.
+type Relay struct {
+	db     *sql.DB
+	outbox *Outbox
+	client *rabbitmq.Client
+	cfg    RelayConfig
+
+	publish func(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+func NewRelay(db *sql.DB, outbox *Outbox, client *rabbitmq.Client, cfg RelayConfig) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.CleanupInterval <= 0 {
+		cfg.CleanupInterval = time.Hour
+	}
+	relay := &Relay{db: db, outbox: outbox, client: client, cfg: cfg}
+	relay.publish = func(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+		return mqutils.Publish(ctx, exchange, key, msg, relay.client)
+	}
+	return relay
+}
+
+// Run polls outbox until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	pollTimer := time.NewTimer(0)
+	defer pollTimer.Stop()
+	cleanupTicker := time.NewTicker(r.cfg.CleanupInterval)
+	defer cleanupTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cleanupTicker.C:
+			if r.cfg.Retention > 0 {
+				if _, err := r.Cleanup(ctx); err != nil {
+					r.reportErr(err)
+				}
+			}
+		case <-pollTimer.C:
+			published, err := r.Poll(ctx)
+			if err != nil {
+				r.reportErr(err)
+			}
+			// rows published without an error mean there are probably more of them waiting
+			if err == nil && published > 0 {
+				pollTimer.Reset(0)
+			} else {
+				pollTimer.Reset(r.cfg.PollInterval)
+			}
+		}
+	}
+}
+
+type pendingRow struct {
+	id  int64
+	msg Message
+}
+
+// Poll publishes up to BatchSize pending rows and returns number of published ones, it stops at the first failure.
+//
+// An aggregate is claimed by locking its oldest pending row, so that a replica never publishes a row
+// while an earlier row of the same aggregate is locked by another replica. Rows following the claimed one
+// are published in order within the same poll.
+func (r *Relay) Poll(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	heads, err := r.selectPending(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var publishErr error
+	// publishAll publishes rows in order and tells whether polling may go on,
+	// errors other than publishErr abort the transaction
+	publishAll := func(rows []pendingRow) (bool, error) {
+		for _, row := range rows {
+			if err := r.publish(ctx, row.msg.Exchange, row.msg.RoutingKey, row.msg.Publishing); err != nil {
+				publishErr = fmt.Errorf("publishing outbox row %d: %w", row.id, err)
+				return false, nil
+			}
+			if err := r.markSent(ctx, tx, row.id); err != nil {
+				// the row will be published once again, consumers are expected to be idempotent anyway
+				return false, err
+			}
+			published++
+		}
+		return true, nil
+	}
+
+	for _, head := range heads {
+		if published >= r.cfg.BatchSize {
+			break
+		}
+		rows := []pendingRow{head}
+		if head.msg.AggregateID != "" {
+			following, err := r.selectFollowing(ctx, tx, head, r.cfg.BatchSize-published-1)
+			if err != nil {
+				return 0, err
+			}
+			rows = append(rows, following...)
+		}
+
+		ok, err := publishAll(rows)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+	}
+
+	// rows published so far are committed as sent, the rest stays pending
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
+
+// selectPending selects rows without aggregate and the oldest pending row of every aggregate
+func (r *Relay) selectPending(ctx context.Context, tx *sql.Tx) ([]pendingRow, error) {
+	t := r.outbox.table
+	query := fmt.Sprintf(
+		`SELECT id, aggregate_id, exchange, routing_key, properties, body FROM %[1]s
+WHERE sent_at IS NULL AND (aggregate_id = '' OR id IN (
+	SELECT MIN(id) FROM %[1]s WHERE sent_at IS NULL AND aggregate_id <> '' GROUP BY aggregate_id
+))
+ORDER BY id LIMIT %[2]d %[3]s`,
+		t, r.cfg.BatchSize, r.outbox.dialect.LockClause,
+	)
+	return r.queryPending(ctx, tx, query)
+}
+
+// selectFollowing selects pending rows of the aggregate claimed by head, other replicas never select them
+// as long as head is locked
+func (r *Relay) selectFollowing(ctx context.Context, tx *sql.Tx, head pendingRow, limit int) ([]pendingRow, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf(
+		`SELECT id, aggregate_id, exchange, routing_key, properties, body FROM %s
+WHERE sent_at IS NULL AND aggregate_id = %s AND id > %s
+ORDER BY id LIMIT %d %s`,
+		r.outbox.table, r.outbox.ph(1), r.outbox.ph(2), limit, r.outbox.dialect.LockClause,
+	)
+	return r.queryPending(ctx, tx, query, head.msg.AggregateID, head.id)
+}
+
+func (r *Relay) queryPending(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]pendingRow, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []pendingRow
+	for rows.Next() {
+		var row pendingRow
+		var props string
+		var body []byte
+		if err := rows.Scan(&row.id, &row.msg.AggregateID, &row.msg.Exchange, &row.msg.RoutingKey, &props, &body); err != nil {
+			return nil, err
+		}
+		if row.msg.Publishing, err = decodeProperties(props, body); err != nil {
+			return nil, errors.Join(fmt.Errorf("decoding outbox row %d", row.id), err)
+		}
+		pending = append(pending, row)
+	}
+	return pending, rows.Err()
+}
+
+func (r *Relay) markSent(ctx context.Context, tx *sql.Tx, id int64) error {
+	query := fmt.Sprintf("UPDATE %s SET sent_at = %s WHERE id = %s", r.outbox.table, r.outbox.ph(1), r.outbox.ph(2))
+	_, err := tx.ExecContext(ctx, query, time.Now().UTC(), id)
+	return err
+}
+
+// Cleanup deletes rows sent earlier than Retention ago and returns number of deleted rows
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < %s", r.outbox.table, r.outbox.ph(1))
+	res, err := r.db.ExecContext(ctx, query, time.Now().UTC().Add(-r.cfg.Retention))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Relay) reportErr(err error) {
+	if r.cfg.ErrCallback != nil {
+		r.cfg.ErrCallback(err)
+	}
+}
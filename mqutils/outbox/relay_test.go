@@ -0,0 +1,231 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type published struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+func newTestRelay(t *testing.T, cfg RelayConfig) (*sql.DB, *Outbox, *Relay, *[]published) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection has its own in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	o := New("", SQLite)
+	if err := o.CreateSchema(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []published
+	relay := NewRelay(db, o, nil, cfg)
+	relay.publish = func(_ context.Context, exchange, key string, msg amqp.Publishing) error {
+		sent = append(sent, published{exchange: exchange, key: key, msg: msg})
+		return nil
+	}
+	return db, o, relay, &sent
+}
+
+func store(t *testing.T, db *sql.DB, o *Outbox, msgs ...Message) {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		if err := o.Store(context.Background(), tx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func message(aggregateID, id string) Message {
+	return Message{
+		Exchange:    "orders",
+		RoutingKey:  "order.created",
+		AggregateID: aggregateID,
+		Publishing: amqp.Publishing{
+			MessageId: id,
+			Headers:   amqp.Table{"attempt": int64(1), "source": "test"},
+			Body:      []byte(id),
+		},
+	}
+}
+
+func ids(sent []published) []string {
+	var result []string
+	for _, p := range sent {
+		result = append(result, p.msg.MessageId)
+	}
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRelayPublishesStoredMessages(t *testing.T) {
+	db, o, relay, sent := newTestRelay(t, RelayConfig{})
+	store(t, db, o, message("", "m1"))
+
+	n, err := relay.Poll(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("Poll() = %d, %v, want 1, nil", n, err)
+	}
+
+	got := (*sent)[0]
+	if got.exchange != "orders" || got.key != "order.created" || string(got.msg.Body) != "m1" {
+		t.Errorf("published %+v", got)
+	}
+	if got.msg.Headers["attempt"] != int64(1) || got.msg.Headers["source"] != "test" {
+		t.Errorf("headers were not restored: %v", got.msg.Headers)
+	}
+
+	// marked as sent, so it's not published again
+	if n, err := relay.Poll(context.Background()); err != nil || n != 0 {
+		t.Fatalf("second Poll() = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func TestRelayKeepsOrderOfAggregate(t *testing.T) {
+	db, o, relay, sent := newTestRelay(t, RelayConfig{})
+	store(t, db, o, message("a", "a1"), message("a", "a2"), message("b", "b1"), message("", "n1"), message("a", "a3"))
+
+	n, err := relay.Poll(context.Background())
+	if err != nil || n != 5 {
+		t.Fatalf("Poll() = %d, %v, want 5, nil", n, err)
+	}
+	// a claimed aggregate is drained within the poll
+	if got, want := ids(*sent), []string{"a1", "a2", "a3", "b1", "n1"}; !equal(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func TestRelayStopsWithinAggregateAtPublishFailure(t *testing.T) {
+	db, o, relay, sent := newTestRelay(t, RelayConfig{})
+	store(t, db, o, message("a", "a1"), message("a", "a2"), message("a", "a3"), message("b", "b1"))
+
+	failure := errors.New("broker is down")
+	relay.publish = func(_ context.Context, exchange, key string, msg amqp.Publishing) error {
+		if msg.MessageId == "a2" {
+			return failure
+		}
+		*sent = append(*sent, published{exchange: exchange, key: key, msg: msg})
+		return nil
+	}
+
+	n, err := relay.Poll(context.Background())
+	if n != 1 || !errors.Is(err, failure) {
+		t.Fatalf("Poll() = %d, %v, want 1, %v", n, err, failure)
+	}
+	if got := ids(*sent); !equal(got, []string{"a1"}) {
+		t.Errorf("published %v, want [a1]", got)
+	}
+}
+
+func TestRelayLimitsBatch(t *testing.T) {
+	db, o, relay, sent := newTestRelay(t, RelayConfig{BatchSize: 2})
+	store(t, db, o, message("a", "a1"), message("a", "a2"), message("a", "a3"), message("", "n1"))
+
+	var rounds [][]string
+	for i := 0; i < 3; i++ {
+		*sent = nil
+		if _, err := relay.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		rounds = append(rounds, ids(*sent))
+	}
+
+	want := [][]string{{"a1", "a2"}, {"a3", "n1"}, nil}
+	for i := range want {
+		if !equal(rounds[i], want[i]) {
+			t.Errorf("round %d published %v, want %v", i, rounds[i], want[i])
+		}
+	}
+}
+
+func TestRelayStopsAtPublishFailure(t *testing.T) {
+	db, o, relay, sent := newTestRelay(t, RelayConfig{})
+	store(t, db, o, message("", "m1"), message("", "m2"))
+
+	failure := errors.New("broker is down")
+	relay.publish = func(_ context.Context, exchange, key string, msg amqp.Publishing) error {
+		if msg.MessageId == "m2" {
+			return failure
+		}
+		*sent = append(*sent, published{exchange: exchange, key: key, msg: msg})
+		return nil
+	}
+
+	n, err := relay.Poll(context.Background())
+	if n != 1 || !errors.Is(err, failure) {
+		t.Fatalf("Poll() = %d, %v, want 1, %v", n, err, failure)
+	}
+
+	// the first row was committed as sent, the failed one stays pending
+	relay.publish = func(_ context.Context, exchange, key string, msg amqp.Publishing) error {
+		*sent = append(*sent, published{exchange: exchange, key: key, msg: msg})
+		return nil
+	}
+	*sent = nil
+	if _, err := relay.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(*sent); !equal(got, []string{"m2"}) {
+		t.Errorf("published %v after failure, want [m2]", got)
+	}
+}
+
+func TestRelayCleanup(t *testing.T) {
+	db, o, relay, _ := newTestRelay(t, RelayConfig{Retention: time.Hour})
+	store(t, db, o, message("", "old"), message("", "recent"))
+	if _, err := relay.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	store(t, db, o, message("", "pending"))
+
+	if _, err := db.Exec("UPDATE outbox SET sent_at = ? WHERE id = 1", time.Now().UTC().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := relay.Cleanup(context.Background())
+	if err != nil || deleted != 1 {
+		t.Fatalf("Cleanup() = %d, %v, want 1, nil", deleted, err)
+	}
+
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 2 {
+		t.Errorf("%d rows left, want recent sent and pending ones", left)
+	}
+}
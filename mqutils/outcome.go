@@ -0,0 +1,125 @@
+package mqutils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type outcomeAction int
+
+const (
+	outcomeRequeue outcomeAction = iota
+	outcomeDeadLetter
+	outcomeRetryAfter
+	outcomeDrop
+	outcomeAckAndReport
+	outcomeDeferAck
+)
+
+// Outcome is an error returned by Handler to tell Router how to settle the message,
+// instead of leaving it to ErrorHandler and its `requeue` result:
+//
+//	if errors.Is(err, ErrRateLimited) {
+//		return mqutils.RetryAfter(30 * time.Second).WithErr(err)
+//	}
+//
+// ErrorHandler is still notified of outcomes, except DeferAck and Drop without an error,
+// but what it returns is ignored. Outcomes wrapped into other errors are recognized as well.
+type Outcome struct {
+	action outcomeAction
+	reason string
+	delay  time.Duration
+	err    error
+}
+
+// Requeue returns the message into the queue, with respect to RedeliveryPolicy of Router
+func Requeue() *Outcome {
+	return &Outcome{action: outcomeRequeue}
+}
+
+// DeadLetter rejects the message without requeue, so that it goes to the dead-letter exchange of the queue if any.
+// The reason is reported to ErrorHandler, since the broker records only "rejected" in x-death header.
+func DeadLetter(reason string) *Outcome {
+	return &Outcome{action: outcomeDeadLetter, reason: reason}
+}
+
+// RetryAfter sends the message into the retry queue with the delay nearest to d, see RetryPolicy.
+// Without RetryPolicy of Router it's the same as Requeue.
+func RetryAfter(d time.Duration) *Outcome {
+	return &Outcome{action: outcomeRetryAfter, delay: d}
+}
+
+// Drop acknowledges the message, so that it's discarded without dead-lettering
+func Drop() *Outcome {
+	return &Outcome{action: outcomeDrop}
+}
+
+// AckAndReport acknowledges the message and reports err to ErrorHandler, e.g. for failures retrying won't fix
+func AckAndReport(err error) *Outcome {
+	return &Outcome{action: outcomeAckAndReport, err: err}
+}
+
+// DeferAck leaves the message unsettled, the handler is responsible for acknowledging it later.
+// A partitioned consumer holds the lane of the message meanwhile, see ConsumerParams.PartitionSettleTimeout.
+func DeferAck() *Outcome {
+	return &Outcome{action: outcomeDeferAck}
+}
+
+// WithErr attaches the error which caused the outcome
+func (o *Outcome) WithErr(err error) *Outcome {
+	withErr := *o
+	withErr.err = err
+	return &withErr
+}
+
+func (o *Outcome) Error() string {
+	var s string
+	switch o.action {
+	case outcomeRequeue:
+		s = "requeue"
+	case outcomeDeadLetter:
+		s = fmt.Sprintf("dead letter: %s", o.reason)
+	case outcomeRetryAfter:
+		s = fmt.Sprintf("retry after %s", o.delay)
+	case outcomeDrop:
+		s = "drop"
+	case outcomeAckAndReport:
+		s = "ack and report"
+	case outcomeDeferAck:
+		s = "defer ack"
+	}
+	if o.err != nil {
+		s = fmt.Sprintf("%s: %s", s, o.err)
+	}
+	return s
+}
+
+func (o *Outcome) Unwrap() error {
+	return o.err
+}
+
+// settle applies the outcome returned by a handler
+func (r *Router) settle(ctx context.Context, msg amqp.Delivery, outcome *Outcome) {
+	if outcome.action != outcomeDeferAck && (outcome.action != outcomeDrop || outcome.err != nil) {
+		_ = r.errorHandler(ctx, msg, outcome)
+	}
+
+	switch outcome.action {
+	case outcomeRequeue:
+		r.requeue(ctx, msg, outcome)
+	case outcomeDeadLetter:
+		r.nack(ctx, msg)
+	case outcomeRetryAfter:
+		if r.retry == nil {
+			r.requeue(ctx, msg, outcome)
+			return
+		}
+		r.retryLater(ctx, msg, outcome, outcome.delay)
+	case outcomeDrop, outcomeAckAndReport:
+		r.ack(ctx, msg)
+	case outcomeDeferAck:
+	}
+}
@@ -0,0 +1,78 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recordingAcknowledger keeps settlements in the order they were made
+type recordingAcknowledger struct {
+	calls []string
+	mx    sync.Mutex
+}
+
+func (a *recordingAcknowledger) Ack(_ uint64, _ bool) error {
+	return a.record("ack")
+}
+
+func (a *recordingAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	return a.record(fmt.Sprintf("nack requeue=%t", requeue))
+}
+
+func (a *recordingAcknowledger) Reject(_ uint64, requeue bool) error {
+	return a.record(fmt.Sprintf("reject requeue=%t", requeue))
+}
+
+func (a *recordingAcknowledger) record(call string) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.calls = append(a.calls, call)
+	return nil
+}
+
+// settled returns settlements made so far, it's safe to call while deliveries are settled concurrently
+func (a *recordingAcknowledger) settled() []string {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return append([]string(nil), a.calls...)
+}
+
+func TestRouterSettlesOutcomes(t *testing.T) {
+	var nilOutcome *Outcome
+
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{name: "success", want: []string{"ack"}},
+		{name: "requeue", err: Requeue(), want: []string{"nack requeue=true"}},
+		{name: "dead letter", err: DeadLetter("invalid"), want: []string{"nack requeue=false"}},
+		{name: "wrapped drop", err: fmt.Errorf("wrapped: %w", Drop()), want: []string{"ack"}},
+		{name: "retry without policy", err: RetryAfter(0), want: []string{"nack requeue=true"}},
+		{name: "defer ack", err: DeferAck(), want: nil},
+		{name: "nil outcome", err: nilOutcome, want: []string{"ack"}},
+		{name: "wrapped nil outcome", err: fmt.Errorf("wrapped: %w", nilOutcome), want: []string{"nack requeue=false"}},
+		{name: "plain error", err: errors.New("failed"), want: []string{"nack requeue=false"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(func(context.Context, amqp.Delivery, error) bool { return false })
+			router.RegisterEventHandler("event", func(context.Context, amqp.Delivery) error { return tt.err })
+
+			ack := &recordingAcknowledger{}
+			router.Consume(context.Background(), amqp.Delivery{Acknowledger: ack, RoutingKey: "event"})
+
+			if !reflect.DeepEqual(ack.calls, tt.want) {
+				t.Errorf("settled %q, want %q", ack.calls, tt.want)
+			}
+		})
+	}
+}
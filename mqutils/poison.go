@@ -0,0 +1,188 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alifcapital/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// set by quorum queues: number of previous deliveries of the message
+	deliveryCountHeader = "x-delivery-count"
+	// set on copies republished into a classic queue instead of requeue
+	redeliveryCountHeader = "x-redelivery-count"
+
+	// original destination of a republished or parked message
+	originalExchangeHeader   = "x-original-exchange"
+	originalRoutingKeyHeader = "x-original-routing-key"
+
+	// failure metadata of a parked message
+	parkedReasonHeader     = "x-parked-reason"
+	parkedAtHeader         = "x-parked-at"
+	parkedDeliveriesHeader = "x-parked-deliveries"
+)
+
+// RedeliveryPolicy limits how many times Router handles the same message.
+// A message which is about to exceed MaxDeliveries is published into ParkingQueue with failure metadata
+// in headers (x-parked-reason, x-parked-at, x-parked-deliveries, x-original-exchange, x-original-routing-key)
+// and acknowledged, instead of being requeued again.
+//
+// Quorum queues count deliveries in x-delivery-count header, including the ones interrupted by a crash.
+// Classic queues don't, so when ClassicQueue is set, Router doesn't nack with requeue, but republishes
+// a copy with incremented x-redelivery-count straight into the queue and acks the original.
+// Deliveries requeued by the broker itself (i.e. on connection loss) are not counted in that case.
+type RedeliveryPolicy struct {
+	// deliveries allowed including the first one
+	MaxDeliveries int
+	// declared durable on first use
+	ParkingQueue string
+	// name of the consumed classic queue, leave empty for quorum queues
+	ClassicQueue string
+	Client       *rabbitmq.Client
+}
+
+// DeliveryCount returns number of previous deliveries of the message,
+// i.e. x-delivery-count of quorum queues or x-redelivery-count of republished copies
+func DeliveryCount(msg amqp.Delivery) int64 {
+	if count, ok := headerInt(msg.Headers[deliveryCountHeader]); ok {
+		return count
+	}
+	count, _ := headerInt(msg.Headers[redeliveryCountHeader])
+	return count
+}
+
+// OriginalRoutingKey returns routing key the message was published with, before it was republished or parked
+func OriginalRoutingKey(msg amqp.Delivery) string {
+	if key, ok := msg.Headers[originalRoutingKeyHeader].(string); ok {
+		return key
+	}
+	return msg.RoutingKey
+}
+
+// OriginalExchange returns exchange the message was published to, before it was republished or parked
+func OriginalExchange(msg amqp.Delivery) string {
+	if exchange, ok := msg.Headers[originalExchangeHeader].(string); ok {
+		return exchange
+	}
+	return msg.Exchange
+}
+
+func headerInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+type redeliveryGuard struct {
+	policy RedeliveryPolicy
+
+	declared bool
+	mx       sync.Mutex
+
+	publish func(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+func newRedeliveryGuard(policy RedeliveryPolicy) (*redeliveryGuard, error) {
+	if policy.MaxDeliveries <= 0 || policy.ParkingQueue == "" || policy.Client == nil {
+		return nil, errors.New("redelivery policy requires MaxDeliveries, ParkingQueue and Client")
+	}
+
+	guard := &redeliveryGuard{policy: policy}
+	guard.publish = func(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+		return Publish(ctx, exchange, key, msg, guard.policy.Client)
+	}
+	return guard, nil
+}
+
+// exceeded tells whether the current delivery is already beyond the limit, e.g. after crashes of the handler
+func (g *redeliveryGuard) exceeded(msg amqp.Delivery) bool {
+	return DeliveryCount(msg)+1 > int64(g.policy.MaxDeliveries)
+}
+
+// last tells whether the current delivery is the last allowed one, so that a failed message is parked
+func (g *redeliveryGuard) last(msg amqp.Delivery) bool {
+	return DeliveryCount(msg)+1 >= int64(g.policy.MaxDeliveries)
+}
+
+// requeue sends a failed message to be handled again
+func (g *redeliveryGuard) requeue(ctx context.Context, msg amqp.Delivery) error {
+	if g.policy.ClassicQueue == "" {
+		return msg.Nack(false, true)
+	}
+
+	republished := DeliveryToPublishing(msg)
+	republished.Headers[redeliveryCountHeader] = DeliveryCount(msg) + 1
+	republished.Headers[originalExchangeHeader] = OriginalExchange(msg)
+	republished.Headers[originalRoutingKeyHeader] = OriginalRoutingKey(msg)
+
+	// the default exchange routes straight into the queue, without copies into other bound queues
+	if err := g.publish(ctx, "", g.policy.ClassicQueue, republished); err != nil {
+		return errors.Join(NewRepublishFailedError(msg), err, msg.Nack(false, true))
+	}
+	return msg.Ack(false)
+}
+
+// park publishes the message into the parking queue and acks it, on failure the message is requeued
+func (g *redeliveryGuard) park(ctx context.Context, msg amqp.Delivery, reason error) error {
+	if err := g.declare(); err != nil {
+		return errors.Join(NewRepublishFailedError(msg), err, msg.Nack(false, true))
+	}
+
+	parked := DeliveryToPublishing(msg)
+	// the parked message is kept until it's inspected
+	parked.Expiration = ""
+	parked.Headers[parkedReasonHeader] = reason.Error()
+	parked.Headers[parkedAtHeader] = time.Now().UTC().Format(time.RFC3339)
+	parked.Headers[parkedDeliveriesHeader] = DeliveryCount(msg) + 1
+	parked.Headers[originalExchangeHeader] = OriginalExchange(msg)
+	parked.Headers[originalRoutingKeyHeader] = OriginalRoutingKey(msg)
+	delete(parked.Headers, redeliveryCountHeader)
+	delete(parked.Headers, deliveryCountHeader)
+
+	if err := g.publish(ctx, "", g.policy.ParkingQueue, parked); err != nil {
+		return errors.Join(NewRepublishFailedError(msg), err, msg.Nack(false, true))
+	}
+	return msg.Ack(false)
+}
+
+func (g *redeliveryGuard) declare() error {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.declared {
+		return nil
+	}
+	if err := g.policy.Client.WithChannel(func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclare(g.policy.ParkingQueue, true, false, false, false, nil)
+		return err
+	}); err != nil {
+		return err
+	}
+	g.declared = true
+	return nil
+}
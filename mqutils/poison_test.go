@@ -0,0 +1,144 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+type publishedMessage struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+// newParkingRouter returns a router whose parked and republished messages are recorded instead of being published
+func newParkingRouter(t *testing.T, classicQueue string) (*Router, *[]publishedMessage) {
+	t.Helper()
+
+	router, err := NewRouterWithConfig(RouterConfig{
+		ErrorHandler: func(context.Context, amqp.Delivery, error) bool { return true },
+		Redelivery: &RedeliveryPolicy{
+			MaxDeliveries: 3,
+			ParkingQueue:  "orders.parked",
+			ClassicQueue:  classicQueue,
+			Client:        &rabbitmq.Client{},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []publishedMessage
+	router.redelivery.declared = true
+	router.redelivery.publish = func(_ context.Context, exchange, key string, msg amqp.Publishing) error {
+		published = append(published, publishedMessage{exchange: exchange, key: key, msg: msg})
+		return nil
+	}
+	return router, &published
+}
+
+func TestPoisonMessageIsParkedOnLastDelivery(t *testing.T) {
+	router, published := newParkingRouter(t, "")
+	var handled int
+	router.RegisterEventHandler("order.created", func(context.Context, amqp.Delivery) error {
+		handled++
+		return errors.New("cannot handle")
+	})
+
+	first := &recordingAcknowledger{}
+	router.Consume(context.Background(), amqp.Delivery{Acknowledger: first, RoutingKey: "order.created"})
+	if !reflect.DeepEqual(first.calls, []string{"nack requeue=true"}) || len(*published) != 0 {
+		t.Fatalf("first delivery settled %q, want requeued", first.calls)
+	}
+
+	last := &recordingAcknowledger{}
+	router.Consume(context.Background(), amqp.Delivery{
+		Acknowledger: last,
+		Exchange:     "orders",
+		RoutingKey:   "order.created",
+		Expiration:   "60000",
+		Headers:      amqp.Table{deliveryCountHeader: int64(2)},
+		Body:         []byte("poison"),
+	})
+	if !reflect.DeepEqual(last.calls, []string{"ack"}) {
+		t.Errorf("last delivery settled %q, want acked after parking", last.calls)
+	}
+	if handled != 2 {
+		t.Errorf("handled %d times, want 2", handled)
+	}
+
+	if len(*published) != 1 {
+		t.Fatalf("published %d messages, want the parked one", len(*published))
+	}
+	parked := (*published)[0]
+	if parked.exchange != "" || parked.key != "orders.parked" || string(parked.msg.Body) != "poison" {
+		t.Errorf("parked %q into %q/%q, want the message in the parking queue", parked.msg.Body, parked.exchange, parked.key)
+	}
+	if parked.msg.Expiration != "" {
+		t.Errorf("parked message expires in %s ms, want it kept", parked.msg.Expiration)
+	}
+	headers := parked.msg.Headers
+	reason, _ := headers[parkedReasonHeader].(string)
+	if !strings.Contains(reason, "cannot handle") || headers[parkedDeliveriesHeader] != int64(3) ||
+		headers[originalExchangeHeader] != "orders" || headers[originalRoutingKeyHeader] != "order.created" {
+		t.Errorf("parked headers %v, want failure metadata", headers)
+	}
+	if _, ok := headers[deliveryCountHeader]; ok {
+		t.Errorf("parked headers %v, want delivery count removed", headers)
+	}
+}
+
+func TestPoisonMessageBeyondLimitIsParkedWithoutHandling(t *testing.T) {
+	router, published := newParkingRouter(t, "")
+	router.RegisterEventHandler("order.created", func(context.Context, amqp.Delivery) error {
+		t.Error("message beyond the limit was handled")
+		return nil
+	})
+
+	ack := &recordingAcknowledger{}
+	router.Consume(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		RoutingKey:   "order.created",
+		Headers:      amqp.Table{deliveryCountHeader: int64(5)},
+	})
+
+	if !reflect.DeepEqual(ack.calls, []string{"ack"}) || len(*published) != 1 {
+		t.Errorf("settled %q with %d messages published, want parked", ack.calls, len(*published))
+	}
+}
+
+func TestClassicQueueRedeliveryIsCounted(t *testing.T) {
+	router, published := newParkingRouter(t, "orders")
+	router.RegisterEventHandler("order.created", func(context.Context, amqp.Delivery) error {
+		return errors.New("cannot handle")
+	})
+
+	ack := &recordingAcknowledger{}
+	router.Consume(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Exchange:     "events",
+		RoutingKey:   "order.created",
+		Headers:      amqp.Table{redeliveryCountHeader: int64(1)},
+	})
+
+	if !reflect.DeepEqual(ack.calls, []string{"ack"}) {
+		t.Errorf("settled %q, want acked after republishing", ack.calls)
+	}
+	if len(*published) != 1 {
+		t.Fatalf("published %d messages, want the republished copy", len(*published))
+	}
+	copied := (*published)[0]
+	if copied.exchange != "" || copied.key != "orders" || copied.msg.Headers[redeliveryCountHeader] != int64(2) {
+		t.Errorf("republished %v into %q/%q, want counted copy in the queue", copied.msg.Headers, copied.exchange, copied.key)
+	}
+	if OriginalRoutingKey(amqp.Delivery{Headers: copied.msg.Headers}) != "order.created" {
+		t.Errorf("republished headers %v, want the original routing key", copied.msg.Headers)
+	}
+}
@@ -31,6 +31,10 @@ func Publish(ctx context.Context, exchange, key string, msg amqp.Publishing, cli
 		return err
 	}
 
+	// messages built by hand may have no headers at all
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
 	msg.Headers[opentracingData] = string(bagItemsJsonBytes)
 	if err := client.Publish(newCtx, exchange, key, false, false, msg); err != nil {
 		ext.LogError(span, err)
@@ -55,3 +59,23 @@ func NewMessage(id string, body []byte) amqp.Publishing {
 		Body:            body,
 	}
 }
+
+// DeliveryToPublishing makes a publishing of the delivery to send it again, headers are copied.
+// UserId is omitted, since broker rejects it unless it matches the user of the connection.
+func DeliveryToPublishing(msg amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         copyHeaders(msg.Headers),
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
@@ -0,0 +1,153 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq/mqutils"
+)
+
+// Filter selects messages to replay, a message is replayed only if all filters match it
+type Filter func(msg amqp.Delivery) bool
+
+// RoutingKey matches messages originally published with any of the keys
+func RoutingKey(keys ...string) Filter {
+	return func(msg amqp.Delivery) bool {
+		_, key := originalDestination(msg)
+		for _, k := range keys {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Header matches messages having the header with the value, values are compared by their text form
+func Header(name, value string) Filter {
+	return func(msg amqp.Delivery) bool {
+		v, ok := msg.Headers[name]
+		if !ok {
+			return false
+		}
+		if b, ok := v.([]byte); ok {
+			return string(b) == value
+		}
+		return fmt.Sprint(v) == value
+	}
+}
+
+// DeathReason matches messages dead-lettered the last time for any of the reasons, see mqutils.Death* constants
+func DeathReason(reasons ...string) Filter {
+	return func(msg amqp.Delivery) bool {
+		death, ok := mqutils.LastDeath(msg)
+		if !ok {
+			return false
+		}
+		for _, reason := range reasons {
+			if reason == death.Reason {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Age matches messages published at least min and at most max ago, zero max means no upper bound.
+// Age is taken from Timestamp, or from the first death time for messages without it.
+func Age(min, max time.Duration) Filter {
+	return func(msg amqp.Delivery) bool {
+		published := msg.Timestamp
+		if published.IsZero() {
+			deaths := mqutils.Deaths(msg)
+			if len(deaths) == 0 {
+				return false
+			}
+			published = deaths[len(deaths)-1].Time
+		}
+
+		age := time.Since(published)
+		return age >= min && (max == 0 || age <= max)
+	}
+}
+
+// JSONPath matches messages whose json body has the value at the path. Only a simple subset of JSONPath
+// is supported: fields separated by dots and array indexes, e.g. "$.order.items[0].sku".
+// Values other than strings are compared by their json form, e.g. "42", "true".
+func JSONPath(path, value string) (Filter, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg amqp.Delivery) bool {
+		var doc any
+		if err := json.Unmarshal(msg.Body, &doc); err != nil {
+			return false
+		}
+		for _, step := range steps {
+			switch s := step.(type) {
+			case string:
+				object, ok := doc.(map[string]any)
+				if !ok {
+					return false
+				}
+				if doc, ok = object[s]; !ok {
+					return false
+				}
+			case int:
+				array, ok := doc.([]any)
+				if !ok || s >= len(array) {
+					return false
+				}
+				doc = array[s]
+			}
+		}
+
+		if s, ok := doc.(string); ok {
+			return s == value
+		}
+		b, _ := json.Marshal(doc)
+		return string(b) == value
+	}, nil
+}
+
+// parseJSONPath splits path into field names (string) and array indexes (int)
+func parseJSONPath(path string) ([]any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, nil
+	}
+
+	var steps []any
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			steps = append(steps, name)
+		}
+		for rest != "" {
+			index, tail, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("invalid json path %q", path)
+			}
+			i, err := strconv.Atoi(index)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid array index %q in json path %q", index, path)
+			}
+			steps = append(steps, i)
+			rest = strings.TrimPrefix(tail, "[")
+			if tail != "" && !strings.HasPrefix(tail, "[") {
+				return nil, fmt.Errorf("invalid json path %q", path)
+			}
+		}
+		if name == "" && !strings.Contains(part, "[") {
+			return nil, fmt.Errorf("invalid json path %q", path)
+		}
+	}
+	return steps, nil
+}
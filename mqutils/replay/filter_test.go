@@ -0,0 +1,65 @@
+package replay
+
+import (
+	"reflect"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []any
+	}{
+		{path: "$", want: nil},
+		{path: "$.order.id", want: []any{"order", "id"}},
+		{path: "order.id", want: []any{"order", "id"}},
+		{path: "$.order.items[0].sku", want: []any{"order", "items", 0, "sku"}},
+		{path: "$.matrix[1][2]", want: []any{"matrix", 1, 2}},
+		{path: "$[3].id", want: []any{3, "id"}},
+	}
+	for _, tt := range tests {
+		got, err := parseJSONPath(tt.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q) error = %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseJSONPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseJSONPathRejectsInvalidPaths(t *testing.T) {
+	for _, path := range []string{"$.order..id", "$.items[x]", "$.items[-1]", "$.items[1", "$.items[1]sku"} {
+		if steps, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) = %v, want error", path, steps)
+		}
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	msg := amqp.Delivery{Body: []byte(`{"order": {"id": 42, "items": [{"sku": "A-1"}, {"sku": "B-2"}]}}`)}
+
+	tests := []struct {
+		path  string
+		value string
+		want  bool
+	}{
+		{path: "$.order.items[1].sku", value: "B-2", want: true},
+		{path: "$.order.items[1].sku", value: "A-1", want: false},
+		{path: "$.order.id", value: "42", want: true},
+		{path: "$.order.items[2].sku", value: "B-2", want: false},
+		{path: "$.order.missing", value: "null", want: false},
+	}
+	for _, tt := range tests {
+		filter, err := JSONPath(tt.path, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filter(msg); got != tt.want {
+			t.Errorf("JSONPath(%q, %q) matched = %t, want %t", tt.path, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Package replay moves messages from a queue (usually a dead-letter or parking one) back to their destination
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+	"github.com/alifcapital/rabbitmq/mqutils"
+)
+
+// ErrUnroutable is reported for a replayed message which the broker returned, since no queue was bound
+// to its destination. Such message stays in the source queue.
+var ErrUnroutable = errors.New("replayed message is not routed to any queue")
+
+// Destination overrides where replayed messages are published to, nil fields keep the original value
+type Destination struct {
+	Exchange   *string
+	RoutingKey *string
+}
+
+type Config struct {
+	// queue to read messages from
+	Source string
+	// messages matching all filters are replayed, all messages when empty
+	Filters []Filter
+	// optional, messages are published to their original exchange and routing key otherwise:
+	// the ones recorded by Router on parking, or by the broker in x-death header on dead-lettering
+	Destination *Destination
+	// maximum number of matching messages to replay (or report in dry run mode), no limit when not set
+	Limit int
+	// maximum number of messages published per second, no limit when not set
+	Rate float64
+	// report matching messages without publishing them, all of them stay in the source queue
+	DryRun bool
+	// optional callback invoked for every message read
+	OnMessage func(msg amqp.Delivery, result Result)
+}
+
+// Result of a single message
+type Result struct {
+	Matched bool
+	// destination of a matching message
+	Exchange   string
+	RoutingKey string
+	// error of publishing or acking the message
+	Err error
+}
+
+// Report summarizes a replay
+type Report struct {
+	Read     int
+	Matched  int
+	Replayed int
+	Failed   int
+}
+
+// Run reads messages from the source queue one by one with manual acknowledgement. A matching message is
+// published as mandatory with confirmation and acked only after the broker confirms it without returning it,
+// so it's never lost.
+//
+// Messages which don't match, fail or are read in dry run mode are held unacked until the end and then
+// returned to the queue, so that they are not read again. Thus, memory of the process grows with the
+// number of such messages. Run stops when it has read as many messages as the queue had ready at start,
+// so messages replayed into the source queue itself are not read again either.
+func Run(ctx context.Context, client *rabbitmq.Client, cfg Config) (Report, error) {
+	var report Report
+
+	err := client.WithChannel(func(ch *amqp.Channel) error {
+		queue, err := ch.QueueDeclarePassive(cfg.Source, false, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		// replays are published on the same channel, so that returns are told apart from other publishers
+		if err := ch.Confirm(false); err != nil {
+			return err
+		}
+		returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+		var held []amqp.Delivery
+		defer func() {
+			// a multiple nack of the last held delivery would nack replayed ones pending in the broker as well,
+			// yet they're acked already, so nacks go one by one
+			for _, msg := range held {
+				_ = msg.Nack(false, true)
+			}
+		}()
+
+		var throttle <-chan time.Time
+		if cfg.Rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+			defer ticker.Stop()
+			throttle = ticker.C
+		}
+
+		for report.Read < queue.Messages && (cfg.Limit <= 0 || report.Matched < cfg.Limit) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			msg, ok, err := ch.Get(cfg.Source, false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// the queue has been drained by someone else meanwhile
+				return nil
+			}
+			report.Read++
+
+			result := Result{Matched: matches(msg, cfg.Filters)}
+			if !result.Matched {
+				held = append(held, msg)
+				notify(cfg, msg, result)
+				continue
+			}
+			report.Matched++
+
+			result.Exchange, result.RoutingKey = destination(msg, cfg.Destination)
+			if cfg.DryRun {
+				held = append(held, msg)
+				notify(cfg, msg, result)
+				continue
+			}
+
+			if throttle != nil {
+				select {
+				case <-throttle:
+				case <-ctx.Done():
+					held = append(held, msg)
+					return ctx.Err()
+				}
+			}
+
+			result.Err = publish(ctx, ch, returns, result.Exchange, result.RoutingKey, mqutils.DeliveryToPublishing(msg))
+			if result.Err != nil {
+				report.Failed++
+				held = append(held, msg)
+			} else if err := msg.Ack(false); err != nil {
+				// the message is published already, it's left to the broker to requeue it on channel close
+				result.Err = errors.Join(mqutils.NewAckFailedError(msg), err)
+				report.Failed++
+			} else {
+				report.Replayed++
+			}
+			notify(cfg, msg, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("replaying from %s: %w", cfg.Source, err)
+	}
+	return report, nil
+}
+
+// publish publishes msg as mandatory and waits for the confirm. The broker returns an unroutable message
+// before confirming it, and returns are dispatched in order with confirms, so one is received by then.
+func publish(ctx context.Context, ch *amqp.Channel, returns <-chan amqp.Return, exchange, key string, msg amqp.Publishing) error {
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("publishing to exchange %q with key %q was not confirmed", exchange, key)
+	}
+
+	select {
+	case returned := <-returns:
+		return fmt.Errorf("%w: exchange %q, key %q: %s", ErrUnroutable, returned.Exchange, returned.RoutingKey, returned.ReplyText)
+	default:
+		return nil
+	}
+}
+
+func matches(msg amqp.Delivery, filters []Filter) bool {
+	for _, filter := range filters {
+		if !filter(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func notify(cfg Config, msg amqp.Delivery, result Result) {
+	if cfg.OnMessage != nil {
+		cfg.OnMessage(msg, result)
+	}
+}
+
+// destination returns the original destination of the message with fields of override replaced
+func destination(msg amqp.Delivery, override *Destination) (string, string) {
+	exchange, key := originalDestination(msg)
+	if override != nil && override.Exchange != nil {
+		exchange = *override.Exchange
+	}
+	if override != nil && override.RoutingKey != nil {
+		key = *override.RoutingKey
+	}
+	return exchange, key
+}
+
+// originalDestination returns exchange and routing key the message was published with before dead-lettering
+func originalDestination(msg amqp.Delivery) (string, string) {
+	// recorded by Router when the message was parked or republished
+	if _, ok := msg.Headers["x-original-routing-key"]; ok {
+		return mqutils.OriginalExchange(msg), mqutils.OriginalRoutingKey(msg)
+	}
+
+	// the oldest entry describes the first publish
+	if deaths := mqutils.Deaths(msg); len(deaths) > 0 {
+		first := deaths[len(deaths)-1]
+		if len(first.RoutingKeys) > 0 {
+			return first.Exchange, first.RoutingKeys[0]
+		}
+		return first.Exchange, msg.RoutingKey
+	}
+
+	return msg.Exchange, msg.RoutingKey
+}
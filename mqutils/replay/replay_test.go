@@ -0,0 +1,34 @@
+package replay
+
+import (
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDestination(t *testing.T) {
+	msg := amqp.Delivery{Headers: amqp.Table{
+		"x-death": []interface{}{
+			amqp.Table{"exchange": "orders", "routing-keys": []interface{}{"order.created"}, "queue": "orders"},
+		},
+	}}
+	empty, key := "", "order.replayed"
+
+	tests := []struct {
+		name         string
+		override     *Destination
+		wantExchange string
+		wantKey      string
+	}{
+		{name: "original", wantExchange: "orders", wantKey: "order.created"},
+		{name: "routing key only", override: &Destination{RoutingKey: &key}, wantExchange: "orders", wantKey: key},
+		{name: "default exchange", override: &Destination{Exchange: &empty}, wantExchange: "", wantKey: "order.created"},
+		{name: "both", override: &Destination{Exchange: &empty, RoutingKey: &key}, wantExchange: "", wantKey: key},
+	}
+	for _, tt := range tests {
+		exchange, routingKey := destination(msg, tt.override)
+		if exchange != tt.wantExchange || routingKey != tt.wantKey {
+			t.Errorf("%s: destination() = %q, %q, want %q, %q", tt.name, exchange, routingKey, tt.wantExchange, tt.wantKey)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alifcapital/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// number of retries a message went through so far
+const retryAttemptHeader = "x-retry-attempt"
+
+const (
+	defaultRetryMaxAttempts = 5
+	defaultRetryBaseDelay   = time.Second
+	defaultRetryMaxDelay    = 5 * time.Minute
+)
+
+// RetryPolicy lets Router retry failed messages with exponential delays instead of requeueing them at once.
+//
+// A failed message is published into "{Queue}.retry" headers exchange, which routes it into a wait queue
+// of its delay "{Queue}.retry.{delay}". Once TTL of the wait queue expires, the message is dead-lettered
+// back into Queue via the default exchange. The original delivery is acked only after the broker confirms
+// the retry publish, otherwise it's requeued. Retry topology is declared on the first retry.
+//
+// After MaxAttempts retries the message is parked when Router has RedeliveryPolicy,
+// or rejected without requeue (i.e. dead-lettered if the queue has a DLX) otherwise.
+type RetryPolicy struct {
+	// name of the consumed queue
+	Queue string
+	// 5 when not set
+	MaxAttempts int
+	// delay of the first retry, it doubles with every next one up to MaxDelay: 1 second and 5 minutes when not set
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// optional, tells whether an error is transient and worth retrying.
+	// When not set, errors for which ErrorHandler returns `requeue` are retried.
+	IsTransient func(err error) bool
+	Client      *rabbitmq.Client
+}
+
+// RetryAttempt returns number of retries the message went through
+func RetryAttempt(msg amqp.Delivery) int64 {
+	attempt, _ := headerInt(msg.Headers[retryAttemptHeader])
+	return attempt
+}
+
+type retrier struct {
+	policy RetryPolicy
+	// delay of every attempt
+	delays []time.Duration
+	// distinct delays, i.e. wait queues
+	tiers []time.Duration
+
+	declared bool
+	mx       sync.Mutex
+
+	publish func(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+func newRetrier(policy RetryPolicy) (*retrier, error) {
+	if policy.Queue == "" || policy.Client == nil {
+		return nil, errors.New("retry policy requires Queue and Client")
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultRetryBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+
+	delays := make([]time.Duration, 0, policy.MaxAttempts)
+	var tiers []time.Duration
+	delay := policy.BaseDelay
+	for i := 0; i < policy.MaxAttempts; i++ {
+		delays = append(delays, min(delay, policy.MaxDelay))
+		// delays only grow, so tiers are sorted
+		if len(tiers) == 0 || tiers[len(tiers)-1] != delays[i] {
+			tiers = append(tiers, delays[i])
+		}
+		delay *= 2
+	}
+
+	retrier := &retrier{policy: policy, delays: delays, tiers: tiers}
+	retrier.publish = func(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+		return Publish(ctx, exchange, key, msg, retrier.policy.Client)
+	}
+	return retrier, nil
+}
+
+func (r *retrier) transient(err error, requeue bool) bool {
+	if r.policy.IsTransient != nil {
+		return r.policy.IsTransient(err)
+	}
+	return requeue
+}
+
+func (r *retrier) exhausted(msg amqp.Delivery) bool {
+	return RetryAttempt(msg) >= int64(r.policy.MaxAttempts)
+}
+
+// retry publishes the message into the wait queue of the next attempt and acks it after confirm
+func (r *retrier) retry(ctx context.Context, msg amqp.Delivery) error {
+	return r.retryAfter(ctx, msg, r.delays[min(RetryAttempt(msg), int64(len(r.delays)-1))])
+}
+
+// retryAfter publishes the message into the wait queue with the delay nearest to the given one
+func (r *retrier) retryAfter(ctx context.Context, msg amqp.Delivery, delay time.Duration) error {
+	if err := r.declare(); err != nil {
+		return errors.Join(NewRepublishFailedError(msg), err, msg.Nack(false, true))
+	}
+
+	retried := DeliveryToPublishing(msg)
+	// the copy must outlive TTL of the wait queue, so its own expiration is dropped
+	retried.Expiration = ""
+	retried.Headers[retryAttemptHeader] = RetryAttempt(msg) + 1
+	retried.Headers[delayTierHeader] = NearestTier(r.tiers, delay).String()
+	retried.Headers[originalExchangeHeader] = OriginalExchange(msg)
+	retried.Headers[originalRoutingKeyHeader] = OriginalRoutingKey(msg)
+	// the retried copy is a new message, previous deliveries must not be counted against it
+	delete(retried.Headers, deliveryCountHeader)
+	delete(retried.Headers, redeliveryCountHeader)
+
+	if err := r.publish(ctx, r.exchange(), r.policy.Queue, retried); err != nil {
+		return errors.Join(NewRepublishFailedError(msg), err, msg.Nack(false, true))
+	}
+	return msg.Ack(false)
+}
+
+func (r *retrier) declare() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.declared {
+		return nil
+	}
+	// expired messages go through the default exchange straight back into the queue
+	if err := r.policy.Client.WithChannel(func(ch *amqp.Channel) error {
+		return declareWaitQueues(ch, r.exchange(), "", r.policy.Queue, r.tiers)
+	}); err != nil {
+		return err
+	}
+	r.declared = true
+	return nil
+}
+
+func (r *retrier) exchange() string {
+	return fmt.Sprintf("%s.retry", r.policy.Queue)
+}
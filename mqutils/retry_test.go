@@ -0,0 +1,114 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+func TestRetryCopyDropsExpiration(t *testing.T) {
+	router, retried := newRetryingRouter(t)
+	router.RegisterEventHandler("order.created", func(context.Context, amqp.Delivery) error {
+		return errors.New("temporary failure")
+	})
+
+	router.Consume(context.Background(), amqp.Delivery{
+		Acknowledger: &recordingAcknowledger{},
+		RoutingKey:   "order.created",
+		Expiration:   "1000",
+	})
+
+	copies := retried()
+	if len(copies) != 1 {
+		t.Fatalf("%d retry copies published, want one", len(copies))
+	}
+	if copies[0].Expiration != "" {
+		t.Errorf("retry copy expires in %s ms, want it to wait for the retry queue TTL", copies[0].Expiration)
+	}
+}
+
+func TestRetryDelays(t *testing.T) {
+	retrier, err := newRetrier(RetryPolicy{
+		Queue:       "orders",
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Second,
+		Client:      &rabbitmq.Client{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDelays := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(retrier.delays, wantDelays) {
+		t.Errorf("delays %v, want %v", retrier.delays, wantDelays)
+	}
+	if wantTiers := wantDelays[:4]; !reflect.DeepEqual(retrier.tiers, wantTiers) {
+		t.Errorf("wait queue tiers %v, want %v", retrier.tiers, wantTiers)
+	}
+
+	if _, err := newRetrier(RetryPolicy{Queue: "orders"}); err == nil {
+		t.Error("newRetrier() accepted policy without Client")
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	var reported []error
+	router, err := NewRouterWithConfig(RouterConfig{
+		ErrorHandler: func(_ context.Context, _ amqp.Delivery, err error) bool {
+			reported = append(reported, err)
+			return true
+		},
+		Retry: &RetryPolicy{Queue: "orders", MaxAttempts: 2, Client: &rabbitmq.Client{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var retried []amqp.Publishing
+	router.retry.declared = true
+	router.retry.publish = func(_ context.Context, _, _ string, msg amqp.Publishing) error {
+		retried = append(retried, msg)
+		return nil
+	}
+	router.RegisterEventHandler("order.created", func(context.Context, amqp.Delivery) error {
+		return errors.New("temporary failure")
+	})
+
+	// the last allowed retry is still published
+	last := &recordingAcknowledger{}
+	router.Consume(context.Background(), amqp.Delivery{
+		Acknowledger: last,
+		RoutingKey:   "order.created",
+		Headers:      amqp.Table{retryAttemptHeader: int64(1)},
+	})
+	if len(retried) != 1 || retried[0].Headers[retryAttemptHeader] != int64(2) {
+		t.Fatalf("retry copies %v, want the second attempt", retried)
+	}
+	if !reflect.DeepEqual(last.calls, []string{"ack"}) {
+		t.Errorf("retried delivery settled %q, want acked", last.calls)
+	}
+
+	exhausted := &recordingAcknowledger{}
+	reported = nil
+	router.Consume(context.Background(), amqp.Delivery{
+		Acknowledger: exhausted,
+		RoutingKey:   "order.created",
+		Headers:      amqp.Table{retryAttemptHeader: int64(2)},
+	})
+	if len(retried) != 1 {
+		t.Errorf("%d retry copies published, want no more after MaxAttempts", len(retried))
+	}
+	if !reflect.DeepEqual(exhausted.calls, []string{"nack requeue=false"}) {
+		t.Errorf("exhausted delivery settled %q, want rejected without requeue", exhausted.calls)
+	}
+	var exhaustedErr *RetriesExhaustedError
+	if len(reported) == 0 || !errors.As(reported[len(reported)-1], &exhaustedErr) {
+		t.Errorf("reported errors %v, want *RetriesExhaustedError", reported)
+	}
+}
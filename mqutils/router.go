@@ -3,6 +3,7 @@ package mqutils
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/alifcapital/rabbitmq"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -10,29 +11,82 @@ import (
 
 // Handler is a function should be some kind of controller.Method
 // where you can handle a kind of business logic or call underlying function
-// and return error which will be handled by underlying ErrorHandler
+// and return error which will be handled by underlying ErrorHandler,
+// or an *Outcome (Requeue, DeadLetter, RetryAfter, Drop ...etc.) deciding how the message is settled
 type Handler func(ctx context.Context, msg amqp.Delivery) error
 
 // ErrorHandler should handle error during Handler phase and return indicator of `requeue`
 // i.e. should message be redelivered again
 type ErrorHandler func(ctx context.Context, msg amqp.Delivery, err error) bool
 
+// BatchHandler handles deliveries of the same routing key at once. Partial failures are reported
+// by returning *rabbitmq.BatchError with indexes of msgs, any other error fails the whole batch.
+type BatchHandler func(ctx context.Context, msgs []amqp.Delivery) error
+
+type RouterConfig struct {
+	ErrorHandler ErrorHandler
+	Middlewares  []Middleware
+	// optional, limits redeliveries of messages failed with requeue, see RedeliveryPolicy.
+	// Batch handlers are not affected.
+	Redelivery *RedeliveryPolicy
+	// optional, retries failed messages with delays, see RetryPolicy. Batch handlers are not affected.
+	Retry *RetryPolicy
+	// consumer_timeout of the broker, TimeoutMiddleware warns about timeouts close to it. 30 minutes when not set.
+	ConsumerTimeout time.Duration
+	// optional, receives warnings about configuration, they are dropped otherwise
+	WarnCallback func(err error)
+}
+
 type Router struct {
 	errorHandler      ErrorHandler
 	eventConsumers    map[string]rabbitmq.IConsumer
+	batchHandlers     map[string]BatchHandler
 	globalMiddlewares []Middleware
+	redelivery        *redeliveryGuard
+	retry             *retrier
+	consumerTimeout   time.Duration
+	warnCallback      func(err error)
 }
 
 func NewRouter(errorHandler ErrorHandler, mids ...Middleware) *Router {
-	return &Router{
-		errorHandler:      errorHandler,
+	router, _ := NewRouterWithConfig(RouterConfig{ErrorHandler: errorHandler, Middlewares: mids})
+	return router
+}
+
+func NewRouterWithConfig(cfg RouterConfig) (*Router, error) {
+	router := &Router{
+		errorHandler:      cfg.ErrorHandler,
 		eventConsumers:    make(map[string]rabbitmq.IConsumer),
-		globalMiddlewares: append([]Middleware{}, mids...),
+		batchHandlers:     make(map[string]BatchHandler),
+		globalMiddlewares: append([]Middleware{}, cfg.Middlewares...),
+		consumerTimeout:   cfg.ConsumerTimeout,
+		warnCallback:      cfg.WarnCallback,
+	}
+	if router.consumerTimeout <= 0 {
+		router.consumerTimeout = defaultBrokerConsumerTimeout
+	}
+
+	if cfg.Redelivery != nil {
+		redelivery, err := newRedeliveryGuard(*cfg.Redelivery)
+		if err != nil {
+			return nil, err
+		}
+		router.redelivery = redelivery
+	}
+	if cfg.Retry != nil {
+		retry, err := newRetrier(*cfg.Retry)
+		if err != nil {
+			return nil, err
+		}
+		router.retry = retry
 	}
+
+	return router, nil
 }
 
+// Consume dispatches the message by its routing key, the original one for messages republished by Router
 func (r *Router) Consume(ctx context.Context, msg amqp.Delivery) {
-	eventConsumer, ok := r.eventConsumers[msg.RoutingKey]
+	eventConsumer, ok := r.eventConsumers[OriginalRoutingKey(msg)]
 	if !ok {
 		err := NewHandlerNotFoundError(msg)
 
@@ -61,35 +115,216 @@ func (r *Router) RegisterEventHandler(eventName string, handler Handler, middlew
 	r.eventConsumers[eventName] = consumer
 }
 
+// RegisterBatchHandler registers handler used when Router is consumed as rabbitmq.IBatchConsumer.
+// Note that middlewares work with single deliveries and thus are not applied to batches.
+func (r *Router) RegisterBatchHandler(eventName string, handler BatchHandler) {
+	r.batchHandlers[eventName] = handler
+}
+
 func (r *Router) GetEventNames() []string {
 	var names []string
 	for k := range r.eventConsumers {
 		names = append(names, k)
 	}
+	for k := range r.batchHandlers {
+		if _, ok := r.eventConsumers[k]; !ok {
+			names = append(names, k)
+		}
+	}
 	return names
 }
 
+// ConsumeBatch splits deliveries by routing key and passes every group to its BatchHandler.
+// Failures of all groups are merged into a single *rabbitmq.BatchError, the client settles deliveries accordingly.
+func (r *Router) ConsumeBatch(ctx context.Context, msgs []amqp.Delivery) error {
+	var keys []string
+	groups := make(map[string][]int)
+	for i, msg := range msgs {
+		if _, ok := groups[msg.RoutingKey]; !ok {
+			keys = append(keys, msg.RoutingKey)
+		}
+		groups[msg.RoutingKey] = append(groups[msg.RoutingKey], i)
+	}
+
+	var failures []rabbitmq.BatchFailure
+	for _, key := range keys {
+		indexes := groups[key]
+		group := make([]amqp.Delivery, 0, len(indexes))
+		for _, i := range indexes {
+			group = append(group, msgs[i])
+		}
+
+		handler, ok := r.batchHandlers[key]
+		if !ok {
+			err := NewHandlerNotFoundError(group[0])
+
+			// when handler for a routing key is not found, retrying it makes no sense
+			_ = r.errorHandler(ctx, group[0], err)
+			for _, i := range indexes {
+				failures = append(failures, rabbitmq.BatchFailure{Index: i, Requeue: false, Err: err})
+			}
+			continue
+		}
+
+		err := handler(ctx, group)
+		if err == nil {
+			continue
+		}
+
+		var batchErr *rabbitmq.BatchError
+		if errors.As(err, &batchErr) {
+			for _, failure := range batchErr.Failures {
+				if failure.Index < 0 || failure.Index >= len(group) {
+					continue
+				}
+				// requeue is decided by the handler, error handler is only notified
+				_ = r.errorHandler(ctx, group[failure.Index], failure.Err)
+				failure.Index = indexes[failure.Index]
+				failures = append(failures, failure)
+			}
+			continue
+		}
+
+		requeue := r.errorHandler(ctx, group[0], err)
+		for _, i := range indexes {
+			failures = append(failures, rabbitmq.BatchFailure{Index: i, Requeue: requeue, Err: err})
+		}
+	}
+
+	if len(failures) > 0 {
+		return &rabbitmq.BatchError{Failures: failures}
+	}
+	return nil
+}
+
 func (r *Router) makeConsumer(handler Handler) rabbitmq.IConsumer {
 	return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
-		if err := handler(ctx, msg); err != nil {
+		// the message was redelivered too many times without being settled, e.g. the handler crashes on it
+		if r.redelivery != nil && r.redelivery.exceeded(msg) {
+			if reserveSettlement(ctx) {
+				r.park(ctx, msg, nil)
+			}
+			return
+		}
+
+		err := handler(ctx, msg)
+
+		// the message was settled already, e.g. by TimeoutMiddleware, so a copy must not be republished either
+		if !reserveSettlement(ctx) {
+			return
+		}
+
+		// the handler decided how the message is settled
+		var outcome *Outcome
+		if errors.As(err, &outcome) {
+			if outcome != nil {
+				r.settle(ctx, msg, outcome)
+				return
+			}
+			// a nil *Outcome returned as error, e.g. `var outcome *Outcome; return outcome`, is no outcome at all,
+			// whereas a wrapped one is handled as an ordinary error
+			if err == error(outcome) {
+				err = nil
+			}
+		}
+
+		if err != nil {
 			// handle error
 			requeue := r.errorHandler(ctx, msg, err)
 
-			// try sending NOT-ACKNOWLEDGED (fail)
-			if err := msg.Nack(false, requeue); err != nil {
-				nackErr := errors.Join(NewNackFailedError(msg), err)
-				_ = r.errorHandler(ctx, msg, nackErr)
+			if r.retry != nil && r.retry.transient(err, requeue) {
+				r.retryLater(ctx, msg, err, 0)
+				return
 			}
+
+			if requeue {
+				r.requeue(ctx, msg, err)
+				return
+			}
+
+			// try sending NOT-ACKNOWLEDGED (fail)
+			r.nack(ctx, msg)
 		} else {
 			// try sending ACKNOWLEDGED (success)
-			if err := msg.Ack(false); err != nil {
-				nackErr := errors.Join(NewAckFailedError(msg), err)
-				_ = r.errorHandler(ctx, msg, nackErr)
-			}
+			r.ack(ctx, msg)
 		}
 	})
 }
 
+func (r *Router) ack(ctx context.Context, msg amqp.Delivery) {
+	if err := msg.Ack(false); err != nil {
+		ackErr := errors.Join(NewAckFailedError(msg), err)
+		_ = r.errorHandler(ctx, msg, ackErr)
+	}
+}
+
+// nack rejects the message without requeue, i.e. dead-letters it if the queue has a DLX
+func (r *Router) nack(ctx context.Context, msg amqp.Delivery) {
+	if err := msg.Nack(false, false); err != nil {
+		nackErr := errors.Join(NewNackFailedError(msg), err)
+		_ = r.errorHandler(ctx, msg, nackErr)
+	}
+}
+
+// requeue returns the message into the queue with respect to RedeliveryPolicy
+func (r *Router) requeue(ctx context.Context, msg amqp.Delivery, err error) {
+	if r.redelivery == nil {
+		if err := msg.Nack(false, true); err != nil {
+			nackErr := errors.Join(NewNackFailedError(msg), err)
+			_ = r.errorHandler(ctx, msg, nackErr)
+		}
+		return
+	}
+
+	if r.redelivery.last(msg) {
+		r.park(ctx, msg, err)
+	} else if err := r.redelivery.requeue(ctx, msg); err != nil {
+		_ = r.errorHandler(ctx, msg, err)
+	}
+}
+
+// retryLater sends the message into a retry queue, the one of the next attempt when delay is zero
+func (r *Router) retryLater(ctx context.Context, msg amqp.Delivery, err error, delay time.Duration) {
+	if r.retry.exhausted(msg) {
+		r.giveUp(ctx, msg, NewRetriesExhaustedError(msg, RetryAttempt(msg), err))
+		return
+	}
+
+	var retryErr error
+	if delay > 0 {
+		retryErr = r.retry.retryAfter(ctx, msg, delay)
+	} else {
+		retryErr = r.retry.retry(ctx, msg)
+	}
+	if retryErr != nil {
+		_ = r.errorHandler(ctx, msg, retryErr)
+	}
+}
+
+// park moves a poison message into the parking queue, ErrorHandler is notified with *PoisonMessageError
+func (r *Router) park(ctx context.Context, msg amqp.Delivery, lastErr error) {
+	poisonErr := NewPoisonMessageError(msg, DeliveryCount(msg)+1, lastErr)
+	_ = r.errorHandler(ctx, msg, poisonErr)
+
+	if err := r.redelivery.park(ctx, msg, poisonErr); err != nil {
+		_ = r.errorHandler(ctx, msg, err)
+	}
+}
+
+// giveUp parks a message which ran out of retries, or rejects it without requeue if there is no parking queue
+func (r *Router) giveUp(ctx context.Context, msg amqp.Delivery, reason error) {
+	_ = r.errorHandler(ctx, msg, reason)
+
+	if r.redelivery != nil {
+		if err := r.redelivery.park(ctx, msg, reason); err != nil {
+			_ = r.errorHandler(ctx, msg, err)
+		}
+		return
+	}
+
+	r.nack(ctx, msg)
+}
+
 func combineConsumerMiddlewares(consumer rabbitmq.IConsumer, mids ...Middleware) rabbitmq.IConsumer {
 	midsLen := len(mids)
 	if midsLen == 0 {
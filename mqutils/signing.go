@@ -0,0 +1,284 @@
+package mqutils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+const (
+	// headers carrying message signature
+	signatureHeader          = "x-signature"
+	signatureKeyIDHeader     = "x-signature-key-id"
+	signatureTimestampHeader = "x-signature-timestamp"
+)
+
+// message properties which may be covered by a signature
+const (
+	SignContentType   = "content_type"
+	SignMessageID     = "message_id"
+	SignCorrelationID = "correlation_id"
+	SignType          = "type"
+	SignAppID         = "app_id"
+	SignUserID        = "user_id"
+	SignReplyTo       = "reply_to"
+)
+
+// SignatureScheme lists message properties and headers which are signed along with the body.
+// Publishers and consumers must use the same scheme.
+type SignatureScheme struct {
+	Properties []string
+	Headers    []string
+}
+
+// Signer is a publish-side HMAC-SHA256 signer
+type Signer struct {
+	keyID  string
+	key    []byte
+	scheme SignatureScheme
+	now    func() time.Time
+}
+
+func NewSigner(keyID string, key []byte, scheme SignatureScheme) *Signer {
+	return &Signer{keyID: keyID, key: key, scheme: scheme, now: time.Now}
+}
+
+// Sign returns a copy of msg with signature, key id and timestamp headers set
+func (s *Signer) Sign(msg amqp.Publishing) (amqp.Publishing, error) {
+	timestamp := s.now().UnixMilli()
+
+	msg.Headers = copyHeaders(msg.Headers)
+	msg.Headers[signatureKeyIDHeader] = s.keyID
+	msg.Headers[signatureTimestampHeader] = timestamp
+
+	mac := hmac.New(sha256.New, s.key)
+	if err := writeSignedContent(mac, s.scheme, s.keyID, timestamp, publishingProperties(msg), msg.Headers, msg.Body); err != nil {
+		return msg, err
+	}
+	msg.Headers[signatureHeader] = hex.EncodeToString(mac.Sum(nil))
+
+	return msg, nil
+}
+
+// Publish signs message and publishes it the same way as Publish does
+func (s *Signer) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing, client *rabbitmq.Client) error {
+	signed, err := s.Sign(msg)
+	if err != nil {
+		return err
+	}
+	return Publish(ctx, exchange, key, signed, client)
+}
+
+// Verifier checks signatures made by Signer, it may know several keys to allow key rotation
+type Verifier struct {
+	keys   map[string][]byte
+	scheme SignatureScheme
+	// messages signed earlier than MaxAge ago are rejected, which prevents replaying of old messages.
+	// Zero value disables the check.
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+func NewVerifier(keys map[string][]byte, scheme SignatureScheme, maxAge time.Duration) *Verifier {
+	return &Verifier{keys: keys, scheme: scheme, maxAge: maxAge, now: time.Now}
+}
+
+func (v *Verifier) Verify(msg amqp.Delivery) error {
+	signature, ok := msg.Headers[signatureHeader].(string)
+	if !ok {
+		return NewInvalidSignatureError(msg, "signature is missing")
+	}
+	keyID, ok := msg.Headers[signatureKeyIDHeader].(string)
+	if !ok {
+		return NewInvalidSignatureError(msg, "key id is missing")
+	}
+	timestamp, ok := msg.Headers[signatureTimestampHeader].(int64)
+	if !ok {
+		return NewInvalidSignatureError(msg, "timestamp is missing")
+	}
+	key, ok := v.keys[keyID]
+	if !ok {
+		return NewInvalidSignatureError(msg, NewUnknownKeyError(keyID).Error())
+	}
+
+	if v.maxAge > 0 {
+		age := v.now().Sub(time.UnixMilli(timestamp))
+		// symmetric bound tolerates clock skew, but doesn't let future-dated messages live longer
+		if age > v.maxAge || age < -v.maxAge {
+			return NewInvalidSignatureError(msg, fmt.Sprintf("signature age %s exceeds %s", age, v.maxAge))
+		}
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return NewInvalidSignatureError(msg, "signature is malformed")
+	}
+	mac := hmac.New(sha256.New, key)
+	if err := writeSignedContent(mac, v.scheme, keyID, timestamp, deliveryProperties(msg), msg.Headers, msg.Body); err != nil {
+		return NewInvalidSignatureError(msg, err.Error())
+	}
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return NewInvalidSignatureError(msg, "signature mismatch")
+	}
+	return nil
+}
+
+type VerificationErrCallback func(ctx context.Context, msg amqp.Delivery, err error)
+
+// NewSignatureVerificationMiddleware passes further only messages with a valid signature,
+// others are rejected without requeue
+func NewSignatureVerificationMiddleware(verifier *Verifier, cb VerificationErrCallback) Middleware {
+	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
+		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
+			if err := verifier.Verify(msg); err != nil {
+				if cb != nil {
+					cb(ctx, msg, err)
+				}
+				if err := msg.Nack(false, false); err != nil && cb != nil {
+					cb(ctx, msg, errors.Join(NewNackFailedError(msg), err))
+				}
+				return
+			}
+
+			next.Consume(ctx, msg)
+		})
+	}
+}
+
+func publishingProperties(msg amqp.Publishing) map[string]string {
+	return map[string]string{
+		SignContentType:   msg.ContentType,
+		SignMessageID:     msg.MessageId,
+		SignCorrelationID: msg.CorrelationId,
+		SignType:          msg.Type,
+		SignAppID:         msg.AppId,
+		SignUserID:        msg.UserId,
+		SignReplyTo:       msg.ReplyTo,
+	}
+}
+
+func deliveryProperties(msg amqp.Delivery) map[string]string {
+	return map[string]string{
+		SignContentType:   msg.ContentType,
+		SignMessageID:     msg.MessageId,
+		SignCorrelationID: msg.CorrelationId,
+		SignType:          msg.Type,
+		SignAppID:         msg.AppId,
+		SignUserID:        msg.UserId,
+		SignReplyTo:       msg.ReplyTo,
+	}
+}
+
+// writeSignedContent writes length-prefixed fields, so that values can't be shifted between each other
+func writeSignedContent(h hash.Hash, scheme SignatureScheme, keyID string, timestamp int64, props map[string]string, headers amqp.Table, body []byte) error {
+	writeField(h, []byte(keyID))
+	writeField(h, []byte(strconv.FormatInt(timestamp, 10)))
+
+	for _, name := range scheme.Properties {
+		value, ok := props[name]
+		if !ok {
+			return fmt.Errorf("unknown message property: %q", name)
+		}
+		writeField(h, []byte(name))
+		writeField(h, []byte(value))
+	}
+	for _, name := range scheme.Headers {
+		writeField(h, []byte(name))
+		// a missing header differs from an empty one
+		value, ok := headers[name]
+		if !ok {
+			h.Write([]byte{0})
+			continue
+		}
+		h.Write([]byte{1})
+		if err := writeHeaderValue(h, value); err != nil {
+			return fmt.Errorf("signed header %q: %w", name, err)
+		}
+	}
+	writeField(h, body)
+
+	return nil
+}
+
+// writeHeaderValue writes a type tag and canonical value, which is the same before publishing and after delivery:
+// integers of any size are written in decimal, and time in RFC 3339 UTC with seconds, as AMQP keeps no more
+func writeHeaderValue(h hash.Hash, value any) error {
+	switch v := value.(type) {
+	case nil:
+		h.Write([]byte{'n'})
+	case string:
+		h.Write([]byte{'s'})
+		writeField(h, []byte(v))
+	case []byte:
+		h.Write([]byte{'x'})
+		writeField(h, v)
+	case bool:
+		h.Write([]byte{'b'})
+		writeField(h, []byte(strconv.FormatBool(v)))
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		n, _ := headerInt(v)
+		h.Write([]byte{'i'})
+		writeField(h, []byte(strconv.FormatInt(n, 10)))
+	case float32:
+		h.Write([]byte{'f'})
+		writeField(h, []byte(strconv.FormatFloat(float64(v), 'g', -1, 64)))
+	case float64:
+		h.Write([]byte{'f'})
+		writeField(h, []byte(strconv.FormatFloat(v, 'g', -1, 64)))
+	case amqp.Decimal:
+		h.Write([]byte{'d'})
+		writeField(h, []byte(fmt.Sprintf("%de-%d", v.Value, v.Scale)))
+	case time.Time:
+		h.Write([]byte{'t'})
+		writeField(h, []byte(v.UTC().Format(time.RFC3339)))
+	case amqp.Table:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		h.Write([]byte{'m'})
+		writeCount(h, len(keys))
+		for _, key := range keys {
+			writeField(h, []byte(key))
+			if err := writeHeaderValue(h, v[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		h.Write([]byte{'a'})
+		writeCount(h, len(v))
+		for _, item := range v {
+			if err := writeHeaderValue(h, item); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported value type: %T", value)
+	}
+	return nil
+}
+
+func writeCount(h hash.Hash, n int) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(n))
+	h.Write(size[:])
+}
+
+func writeField(h hash.Hash, b []byte) {
+	writeCount(h, len(b))
+	h.Write(b)
+}
@@ -0,0 +1,135 @@
+package mqutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var testScheme = SignatureScheme{
+	Properties: []string{SignMessageID, SignType},
+	Headers:    []string{"tenant"},
+}
+
+// signed signs msg at the given time and returns it the way a consumer receives it
+func signed(t *testing.T, signer *Signer, at time.Time, msg amqp.Publishing) amqp.Delivery {
+	t.Helper()
+
+	signer.now = func() time.Time { return at }
+	signedMsg, err := signer.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{
+		Headers:   signedMsg.Headers,
+		MessageId: signedMsg.MessageId,
+		Type:      signedMsg.Type,
+		Body:      signedMsg.Body,
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+	signer := NewSigner("k1", []byte("secret"), testScheme)
+	verifier := NewVerifier(map[string][]byte{"k1": []byte("secret")}, testScheme, time.Minute)
+	verifier.now = func() time.Time { return now }
+
+	msg := amqp.Publishing{
+		MessageId: "m1",
+		Type:      "order.created",
+		Headers:   amqp.Table{"tenant": "acme"},
+		Body:      []byte(`{"amount": 10}`),
+	}
+	delivery := signed(t, signer, now, msg)
+	if err := verifier.Verify(delivery); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	tampered := map[string]func(msg *amqp.Delivery){
+		"body":             func(msg *amqp.Delivery) { msg.Body = []byte(`{"amount": 1000}`) },
+		"signed property":  func(msg *amqp.Delivery) { msg.Type = "order.cancelled" },
+		"signed header":    func(msg *amqp.Delivery) { msg.Headers["tenant"] = "other" },
+		"removed header":   func(msg *amqp.Delivery) { delete(msg.Headers, "tenant") },
+		"timestamp":        func(msg *amqp.Delivery) { msg.Headers[signatureTimestampHeader] = now.Add(time.Second).UnixMilli() },
+		"missing key id":   func(msg *amqp.Delivery) { delete(msg.Headers, signatureKeyIDHeader) },
+		"unknown key id":   func(msg *amqp.Delivery) { msg.Headers[signatureKeyIDHeader] = "k2" },
+		"malformed digest": func(msg *amqp.Delivery) { msg.Headers[signatureHeader] = "not hex" },
+	}
+	for name, tamper := range tampered {
+		msg := delivery
+		msg.Headers = copyHeaders(delivery.Headers)
+		tamper(&msg)
+
+		var invalid *InvalidSignatureError
+		if err := verifier.Verify(msg); !errors.As(err, &invalid) {
+			t.Errorf("%s: Verify() = %v, want *InvalidSignatureError", name, err)
+		}
+	}
+
+	// properties and headers out of the scheme are not signed
+	unsigned := delivery
+	unsigned.Headers = copyHeaders(delivery.Headers)
+	unsigned.Headers["trace"] = "abc"
+	unsigned.CorrelationId = "c1"
+	if err := verifier.Verify(unsigned); err != nil {
+		t.Errorf("Verify() with unsigned fields changed = %v", err)
+	}
+}
+
+func TestVerifyRejectsStaleSignature(t *testing.T) {
+	now := time.Now()
+	signer := NewSigner("k1", []byte("secret"), testScheme)
+	verifier := NewVerifier(map[string][]byte{"k1": []byte("secret")}, testScheme, time.Minute)
+	verifier.now = func() time.Time { return now }
+
+	for _, at := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		var invalid *InvalidSignatureError
+		if err := verifier.Verify(signed(t, signer, at, amqp.Publishing{})); !errors.As(err, &invalid) {
+			t.Errorf("signed at %s: Verify() = %v, want *InvalidSignatureError", at.Sub(now), err)
+		}
+	}
+}
+
+func TestSignedHeaderEncoding(t *testing.T) {
+	now := time.Now()
+	scheme := SignatureScheme{Headers: []string{"tenant", "created_at", "attempt"}}
+	signer := NewSigner("k1", []byte("secret"), scheme)
+	verifier := NewVerifier(map[string][]byte{"k1": []byte("secret")}, scheme, time.Minute)
+	verifier.now = func() time.Time { return now }
+
+	createdAt := time.Date(2024, 5, 1, 12, 30, 15, 999, time.FixedZone("UTC+5", 5*3600))
+	delivery := signed(t, signer, now, amqp.Publishing{Headers: amqp.Table{
+		"tenant":     "",
+		"created_at": createdAt,
+		"attempt":    1,
+	}})
+
+	// the broker delivers time in UTC with seconds and integers as int64
+	delivery.Headers["created_at"] = time.Date(2024, 5, 1, 7, 30, 15, 0, time.UTC)
+	delivery.Headers["attempt"] = int64(1)
+	if err := verifier.Verify(delivery); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	tampered := map[string]func(headers amqp.Table){
+		"removed empty header": func(headers amqp.Table) { delete(headers, "tenant") },
+		"changed time":         func(headers amqp.Table) { headers["created_at"] = createdAt.Add(time.Second) },
+		"integer as string":    func(headers amqp.Table) { headers["attempt"] = "1" },
+	}
+	for name, tamper := range tampered {
+		msg := delivery
+		msg.Headers = copyHeaders(delivery.Headers)
+		tamper(msg.Headers)
+
+		var invalid *InvalidSignatureError
+		if err := verifier.Verify(msg); !errors.As(err, &invalid) {
+			t.Errorf("%s: Verify() = %v, want *InvalidSignatureError", name, err)
+		}
+	}
+
+	if _, err := signer.Sign(amqp.Publishing{Headers: amqp.Table{"tenant": struct{}{}}}); err == nil {
+		t.Error("header of unsupported type was signed")
+	}
+}
@@ -0,0 +1,20 @@
+package mqutils
+
+import "fmt"
+
+// SQLPlaceholder returns n-th (starting from 1) query argument placeholder of a database.
+//
+// SQL stores (SQLIdempotencyStore, SQLOffsetStore and inbox.Inbox) work with PostgreSQL, MySQL and SQLite:
+// their tables use only VARCHAR, BIGINT and TIMESTAMP columns, and their queries avoid upserts.
+// A failed insert is followed by a lookup of the row, rather than by matching vendor codes of a duplicate key.
+type SQLPlaceholder func(n int) string
+
+// DollarPlaceholder is used by PostgreSQL
+func DollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// QuestionPlaceholder is used by MySQL and SQLite
+func QuestionPlaceholder(int) string {
+	return "?"
+}
@@ -0,0 +1,137 @@
+package mqutils
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/alifcapital/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// default consumer_timeout of RabbitMQ
+const defaultBrokerConsumerTimeout = 30 * time.Minute
+
+// a timeout above this share of consumer_timeout leaves too little room for deliveries waiting in prefetch buffer
+const consumerTimeoutWarningRatio = 0.8
+
+// TimeoutMiddleware cancels handler context after timeout and settles the message with the outcome
+// (Requeue when nil) right away, without waiting for the handler. Thus, a stuck handler doesn't hold
+// its delivery until the broker's consumer_timeout closes the channel, along with all consumers on it.
+// Pass it to RegisterEventHandler for routes which need it:
+//
+//	router.RegisterEventHandler("report.requested", handler, router.TimeoutMiddleware(time.Minute, mqutils.RetryAfter(time.Hour)))
+//
+// Settlements made by the handler after the timeout are ignored, and so is the error it returns: Router doesn't
+// retry, requeue or park the message once more, as its outcome is settled already. The middleware still waits for
+// the handler to return, so that the number of running handlers stays bounded by consumer concurrency.
+//
+// A warning is reported when timeout is close to RouterConfig.ConsumerTimeout.
+func (r *Router) TimeoutMiddleware(timeout time.Duration, outcome *Outcome) Middleware {
+	if outcome == nil {
+		outcome = Requeue()
+	}
+	if timeout >= time.Duration(float64(r.consumerTimeout)*consumerTimeoutWarningRatio) {
+		r.warn(NewConsumerTimeoutWarning(timeout, r.consumerTimeout))
+	}
+
+	return func(next rabbitmq.IConsumer) rabbitmq.IConsumer {
+		return rabbitmq.ConsumerFunc(func(ctx context.Context, msg amqp.Delivery) {
+			handlerCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			guarded := msg
+			var guard *settleOnceAcknowledger
+			if msg.Acknowledger != nil {
+				guard = &settleOnceAcknowledger{Acknowledger: msg.Acknowledger}
+				guarded.Acknowledger = guard
+				handlerCtx = context.WithValue(handlerCtx, settleGuardKey{}, guard)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				next.Consume(handlerCtx, guarded)
+			}()
+
+			select {
+			case <-done:
+				return
+			case <-handlerCtx.Done():
+			}
+
+			// the deadline of the parent context (e.g. client shutdown) is not a timeout of the handler
+			if ctx.Err() == nil && guard != nil && guard.claim() {
+				timeoutErr := NewHandlerTimeoutError(msg, timeout)
+				// settling may publish into a retry or parking queue, which must not fail due to the timeout
+				r.settle(context.WithoutCancel(ctx), msg, outcome.WithErr(timeoutErr))
+			}
+			<-done
+		})
+	}
+}
+
+func (r *Router) warn(err error) {
+	if r.warnCallback != nil {
+		r.warnCallback(err)
+	}
+}
+
+type settleGuardKey struct{}
+
+// reserveSettlement is called by Router before it settles the message in any way, including republishing,
+// it returns false when the message was settled by TimeoutMiddleware already
+func reserveSettlement(ctx context.Context) bool {
+	guard, ok := ctx.Value(settleGuardKey{}).(*settleOnceAcknowledger)
+	return !ok || guard.reserve()
+}
+
+const (
+	settlementFree int32 = iota
+	// reserved by the handler path, its first settlement goes through
+	settlementReserved
+	settlementDone
+)
+
+// settleOnceAcknowledger lets only the first settlement of a delivery through, later ones are ignored
+type settleOnceAcknowledger struct {
+	amqp.Acknowledger
+	state atomic.Int32
+}
+
+// claim takes the settlement over for the caller, who must settle via the original acknowledger
+func (a *settleOnceAcknowledger) claim() bool {
+	return a.state.CompareAndSwap(settlementFree, settlementDone)
+}
+
+// reserve keeps the settlement for the handler path, unless it's claimed already
+func (a *settleOnceAcknowledger) reserve() bool {
+	return a.state.CompareAndSwap(settlementFree, settlementReserved) || a.state.Load() == settlementReserved
+}
+
+// settle tells whether a settlement goes through
+func (a *settleOnceAcknowledger) settle() bool {
+	return a.state.CompareAndSwap(settlementFree, settlementDone) ||
+		a.state.CompareAndSwap(settlementReserved, settlementDone)
+}
+
+func (a *settleOnceAcknowledger) Ack(tag uint64, multiple bool) error {
+	if !a.settle() {
+		return nil
+	}
+	return a.Acknowledger.Ack(tag, multiple)
+}
+
+func (a *settleOnceAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	if !a.settle() {
+		return nil
+	}
+	return a.Acknowledger.Nack(tag, multiple, requeue)
+}
+
+func (a *settleOnceAcknowledger) Reject(tag uint64, requeue bool) error {
+	if !a.settle() {
+		return nil
+	}
+	return a.Acknowledger.Reject(tag, requeue)
+}
@@ -0,0 +1,78 @@
+package mqutils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/alifcapital/rabbitmq"
+)
+
+// newRetryingRouter returns a router whose retries are recorded instead of being published
+func newRetryingRouter(t *testing.T) (*Router, func() []amqp.Publishing) {
+	t.Helper()
+
+	router, err := NewRouterWithConfig(RouterConfig{
+		ErrorHandler: func(context.Context, amqp.Delivery, error) bool { return true },
+		Retry:        &RetryPolicy{Queue: "orders", Client: &rabbitmq.Client{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var retried []amqp.Publishing
+	var mx sync.Mutex
+	router.retry.declared = true
+	router.retry.publish = func(_ context.Context, _, _ string, msg amqp.Publishing) error {
+		mx.Lock()
+		defer mx.Unlock()
+		retried = append(retried, msg)
+		return nil
+	}
+	return router, func() []amqp.Publishing {
+		mx.Lock()
+		defer mx.Unlock()
+		return retried
+	}
+}
+
+func TestTimeoutSettlesOnceWhenHandlerFailsLate(t *testing.T) {
+	router, retried := newRetryingRouter(t)
+	router.RegisterEventHandler("report.requested", func(ctx context.Context, msg amqp.Delivery) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		return errors.New("failed after the timeout")
+	}, router.TimeoutMiddleware(10*time.Millisecond, RetryAfter(time.Minute)))
+
+	ack := &recordingAcknowledger{}
+	router.Consume(context.Background(), amqp.Delivery{Acknowledger: ack, RoutingKey: "report.requested"})
+
+	if copies := retried(); len(copies) != 1 {
+		t.Errorf("%d retry copies published, want exactly one", len(copies))
+	}
+	if !reflect.DeepEqual(ack.calls, []string{"ack"}) {
+		t.Errorf("settled %q, want a single ack after the retry copy", ack.calls)
+	}
+}
+
+func TestTimeoutLetsHandlerInTimeSettle(t *testing.T) {
+	router, retried := newRetryingRouter(t)
+	router.RegisterEventHandler("report.requested", func(context.Context, amqp.Delivery) error {
+		return errors.New("failed in time")
+	}, router.TimeoutMiddleware(time.Minute, nil))
+
+	ack := &recordingAcknowledger{}
+	router.Consume(context.Background(), amqp.Delivery{Acknowledger: ack, RoutingKey: "report.requested"})
+
+	if copies := retried(); len(copies) != 1 || copies[0].Headers[retryAttemptHeader] != int64(1) {
+		t.Errorf("retry copies %v, want the first attempt", copies)
+	}
+	if !reflect.DeepEqual(ack.calls, []string{"ack"}) {
+		t.Errorf("settled %q, want a single ack after the retry copy", ack.calls)
+	}
+}
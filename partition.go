@@ -0,0 +1,160 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PartitionKeyFunc extracts an ordering key of a delivery, deliveries with the same key are handled sequentially
+type PartitionKeyFunc func(msg amqp.Delivery) string
+
+// HeaderPartitionKey uses value of a header as the key
+func HeaderPartitionKey(header string) PartitionKeyFunc {
+	return func(msg amqp.Delivery) string {
+		value, ok := msg.Headers[header]
+		if !ok {
+			return ""
+		}
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+// RoutingPartitionKey uses routing key as the key
+func RoutingPartitionKey() PartitionKeyFunc {
+	return func(msg amqp.Delivery) string {
+		return msg.RoutingKey
+	}
+}
+
+// BodyFieldPartitionKey uses a field of json body as the key, nested fields are separated by dots: "account.id".
+// Deliveries with a non-json body or without the field share the empty key.
+func BodyFieldPartitionKey(field string) PartitionKeyFunc {
+	path := strings.Split(field, ".")
+
+	return func(msg amqp.Delivery) string {
+		var value any
+		if err := json.Unmarshal(msg.Body, &value); err != nil {
+			return ""
+		}
+		for _, name := range path {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return ""
+			}
+			value = object[name]
+		}
+		switch v := value.(type) {
+		case nil:
+			return ""
+		case string:
+			return v
+		default:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	}
+}
+
+// startLanes hashes deliveries into a fixed set of lanes, each lane handles its deliveries one by one.
+//
+// A lane moves on to the next delivery only after the previous one is settled (acked, nacked or rejected),
+// so handlers acknowledging asynchronously keep the order as well. Thus, handlers which don't settle
+// a delivery stall their lane until PartitionSettleTimeout, if it's set. Unless AutoAck is set,
+// in which case the lane moves on when the handler returns.
+//
+// Note that a delivery nacked with requeue goes to the back of the queue and loses its place in order,
+// so ordered consumers should rather reject failed deliveries into a dead-letter or retry queue.
+func (c *Client) startLanes(consumer *managedConsumer, deliveries <-chan amqp.Delivery, buffer int) {
+	lanes := make([]chan amqp.Delivery, consumer.workers())
+	done := make(chan struct{})
+
+	for i := range lanes {
+		lane := make(chan amqp.Delivery, buffer)
+		lanes[i] = lane
+
+		c.spawn(consumer, func() {
+			for msg := range lane {
+				settled := make(chan struct{})
+				if !consumer.AutoAck && msg.Acknowledger != nil {
+					msg.Acknowledger = &settleNotifier{Acknowledger: msg.Acknowledger, settled: settled}
+				} else {
+					close(settled)
+				}
+
+				consumer.busyWorkers.Add(1)
+				c.handle(consumer, msg)
+
+				waitSettled(settled, done, consumer.PartitionSettleTimeout)
+				consumer.busyWorkers.Add(-1)
+			}
+		})
+	}
+
+	c.spawn(consumer, func() {
+		defer func() {
+			close(done)
+			for _, lane := range lanes {
+				close(lane)
+			}
+		}()
+
+		for msg := range deliveries {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(consumer.PartitionKey(msg)))
+			lanes[h.Sum32()%uint32(len(lanes))] <- msg
+		}
+	})
+}
+
+// waitSettled blocks until the delivery is settled, the channel is gone or timeout (when positive) passes
+func waitSettled(settled, done <-chan struct{}, timeout time.Duration) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-settled:
+	case <-done:
+		// channel is gone, the delivery can't be settled anymore
+	case <-expired:
+		// the delivery stays unsettled, next ones of the lane go ahead of it
+	}
+}
+
+// settleNotifier closes settled channel once delivery is acknowledged in any way
+type settleNotifier struct {
+	amqp.Acknowledger
+	settled chan struct{}
+	once    sync.Once
+}
+
+func (n *settleNotifier) Ack(tag uint64, multiple bool) error {
+	defer n.notify()
+	return n.Acknowledger.Ack(tag, multiple)
+}
+
+func (n *settleNotifier) Nack(tag uint64, multiple, requeue bool) error {
+	defer n.notify()
+	return n.Acknowledger.Nack(tag, multiple, requeue)
+}
+
+func (n *settleNotifier) Reject(tag uint64, requeue bool) error {
+	defer n.notify()
+	return n.Acknowledger.Reject(tag, requeue)
+}
+
+func (n *settleNotifier) notify() {
+	n.once.Do(func() { close(n.settled) })
+}
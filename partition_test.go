@@ -0,0 +1,95 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// nopAcknowledger accepts settlements of deliveries handled concurrently
+type nopAcknowledger struct{}
+
+func (nopAcknowledger) Ack(uint64, bool) error        { return nil }
+func (nopAcknowledger) Nack(uint64, bool, bool) error { return nil }
+func (nopAcknowledger) Reject(uint64, bool) error     { return nil }
+
+func TestLanesKeepOrderOfPartition(t *testing.T) {
+	client := newTestClient(t)
+
+	handled := make(map[string][]int)
+	var mx sync.Mutex
+	consumer := newManagedConsumer(AMQPConsumer{
+		ConsumerParams: ConsumerParams{Concurrency: 4, PartitionKey: HeaderPartitionKey("account")},
+		IConsumer: ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+			mx.Lock()
+			account := msg.Headers["account"].(string)
+			handled[account] = append(handled[account], int(msg.DeliveryTag))
+			mx.Unlock()
+
+			// settled asynchronously, the lane waits for it anyway
+			go func() {
+				time.Sleep(time.Duration(msg.DeliveryTag%3) * time.Millisecond)
+				_ = msg.Ack(false)
+			}()
+		}),
+	})
+
+	deliveries := make(chan amqp.Delivery)
+	client.startLanes(consumer, deliveries, 1)
+
+	want := make(map[string][]int)
+	for tag := 1; tag <= 30; tag++ {
+		account := fmt.Sprintf("account-%d", tag%5)
+		want[account] = append(want[account], tag)
+		deliveries <- amqp.Delivery{Acknowledger: nopAcknowledger{}, DeliveryTag: uint64(tag), Headers: amqp.Table{"account": account}}
+	}
+	close(deliveries)
+	consumer.wg.Wait()
+
+	if !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v, want deliveries of every account in order", handled)
+	}
+}
+
+func TestLaneMovesOnAfterSettleTimeout(t *testing.T) {
+	client := newTestClient(t)
+
+	handled := make(chan uint64, 2)
+	consumer := newManagedConsumer(AMQPConsumer{
+		ConsumerParams: ConsumerParams{
+			PartitionKey:           RoutingPartitionKey(),
+			PartitionSettleTimeout: 20 * time.Millisecond,
+		},
+		IConsumer: ConsumerFunc(func(_ context.Context, msg amqp.Delivery) {
+			// left unsettled on purpose, e.g. buffered until later
+			handled <- msg.DeliveryTag
+		}),
+	})
+
+	deliveries := make(chan amqp.Delivery, 2)
+	deliveries <- amqp.Delivery{Acknowledger: nopAcknowledger{}, DeliveryTag: 1, RoutingKey: "orders"}
+	deliveries <- amqp.Delivery{Acknowledger: nopAcknowledger{}, DeliveryTag: 2, RoutingKey: "orders"}
+	client.startLanes(consumer, deliveries, 1)
+
+	if tag := <-handled; tag != 1 {
+		t.Fatalf("handled delivery %d first, want 1", tag)
+	}
+	select {
+	case tag := <-handled:
+		t.Fatalf("delivery %d was handled before the previous one was settled or timed out", tag)
+	case <-time.After(5 * time.Millisecond):
+	}
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("lane didn't move on after the settle timeout")
+	}
+
+	close(deliveries)
+	consumer.wg.Wait()
+}
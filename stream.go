@@ -0,0 +1,185 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// consumer argument selecting where to start reading a stream, and the header with offset of a delivery
+	streamOffsetKey = "x-stream-offset"
+
+	defaultStreamCommitInterval = 5 * time.Second
+)
+
+// StreamOffset is a position in a stream queue to start consuming from
+type StreamOffset struct {
+	value any
+}
+
+// StreamFirst starts from the very first message available in the stream
+func StreamFirst() StreamOffset {
+	return StreamOffset{value: "first"}
+}
+
+// StreamLast starts from the last written chunk of messages
+func StreamLast() StreamOffset {
+	return StreamOffset{value: "last"}
+}
+
+// StreamNext starts from messages written after the consumer subscribed
+func StreamNext() StreamOffset {
+	return StreamOffset{value: "next"}
+}
+
+// StreamOffsetAt starts from the exact offset
+func StreamOffsetAt(offset int64) StreamOffset {
+	return StreamOffset{value: offset}
+}
+
+// StreamTimestamp starts from messages written at the given time or later (with seconds precision)
+func StreamTimestamp(t time.Time) StreamOffset {
+	return StreamOffset{value: t}
+}
+
+// OffsetStore keeps offsets of stream consumers by consumer id
+type OffsetStore interface {
+	// Load returns false when there is no offset for the consumer yet
+	Load(ctx context.Context, consumerID string) (offset int64, ok bool, err error)
+	Save(ctx context.Context, consumerID string, offset int64) error
+}
+
+// StreamParams turn a consumer into a stream queue consumer, which tracks offsets of handled deliveries
+// and resumes from the next one after reconnect or restart, instead of reading the stream from the start.
+// Offsets are kept by ConsumerID, so it must be set explicitly and stay the same between restarts.
+//
+// Offset of a delivery is considered processed once the handler returns, so stream consumers
+// handle deliveries sequentially. Note that a stream doesn't delete messages on ack,
+// yet broker requires acknowledgements to keep delivering with respect to prefetch.
+type StreamParams struct {
+	// where to start when Store has no offset of the consumer yet, StreamNext when not set
+	Offset StreamOffset
+	// optional, without it offsets are kept in memory and survive reconnects, but not restarts
+	Store OffsetStore
+	// processed offset is saved into Store every CommitInterval (5 seconds when not set) and when consumer stops
+	CommitInterval time.Duration
+}
+
+type streamState struct {
+	// offset of the last handled delivery, -1 when nothing was handled yet
+	processed atomic.Int64
+	committed atomic.Int64
+}
+
+func newStreamState() *streamState {
+	s := &streamState{}
+	s.processed.Store(-1)
+	s.committed.Store(-1)
+	return s
+}
+
+// validateStreamConsumer checks settings streams rely on
+func validateStreamConsumer(consumer *managedConsumer, cfg ClientConfig) error {
+	if consumer.AutoAck {
+		return fmt.Errorf("stream consumer %s requires manual acknowledgement", consumer.ConsumerID)
+	}
+	if consumer.Concurrency > 1 || consumer.PartitionKey != nil || consumer.IBatchConsumer != nil {
+		return fmt.Errorf("stream consumer %s must handle deliveries sequentially", consumer.ConsumerID)
+	}
+
+	prefetch := cfg.ConsumerQos
+	if consumer.DedicatedChannel {
+		prefetch = consumer.qos(cfg).PrefetchCount
+	} else if perConsumer := consumer.perConsumerPrefetch(cfg); perConsumer > 0 {
+		prefetch = perConsumer
+	}
+	if prefetch <= 0 {
+		return fmt.Errorf("stream consumer %s requires prefetch count", consumer.ConsumerID)
+	}
+	return nil
+}
+
+// streamArgs returns consumer arguments with the offset to start from:
+// the next one after processed (or stored) offset, or the configured one
+func (c *Client) streamArgs(consumer *managedConsumer) (amqp.Table, error) {
+	args := amqp.Table{}
+	for k, v := range consumer.ConsumerParams.Args {
+		args[k] = v
+	}
+
+	offset := consumer.stream.processed.Load()
+	if offset < 0 && consumer.Stream.Store != nil {
+		stored, ok, err := consumer.Stream.Store.Load(c.ctx, consumer.ConsumerID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			offset = stored
+			consumer.stream.processed.Store(stored)
+			consumer.stream.committed.Store(stored)
+		}
+	}
+
+	switch {
+	case offset >= 0:
+		args[streamOffsetKey] = offset + 1
+	case consumer.Stream.Offset.value != nil:
+		args[streamOffsetKey] = consumer.Stream.Offset.value
+	default:
+		args[streamOffsetKey] = StreamNext().value
+	}
+	return args, nil
+}
+
+// startStreaming handles deliveries one by one and commits processed offset periodically
+func (c *Client) startStreaming(consumer *managedConsumer, deliveries <-chan amqp.Delivery) {
+	interval := consumer.Stream.CommitInterval
+	if interval <= 0 {
+		interval = defaultStreamCommitInterval
+	}
+
+	c.spawn(consumer, func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// the last commit must happen even when client is closing
+		defer c.commitStreamOffset(context.WithoutCancel(c.ctx), consumer)
+
+		for {
+			select {
+			case msg, ok := <-deliveries:
+				if !ok {
+					return
+				}
+
+				consumer.busyWorkers.Add(1)
+				c.handle(consumer, msg)
+				consumer.busyWorkers.Add(-1)
+
+				if offset, ok := msg.Headers[streamOffsetKey].(int64); ok {
+					consumer.stream.processed.Store(offset)
+				}
+			case <-ticker.C:
+				c.commitStreamOffset(c.ctx, consumer)
+			}
+		}
+	})
+}
+
+func (c *Client) commitStreamOffset(ctx context.Context, consumer *managedConsumer) {
+	if consumer.Stream.Store == nil {
+		return
+	}
+	processed := consumer.stream.processed.Load()
+	if processed < 0 || processed == consumer.stream.committed.Load() {
+		return
+	}
+	if err := consumer.Stream.Store.Save(ctx, consumer.ConsumerID, processed); err != nil {
+		consumer.recordErr(err)
+		return
+	}
+	consumer.stream.committed.Store(processed)
+}
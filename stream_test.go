@@ -0,0 +1,18 @@
+package rabbitmq
+
+import "testing"
+
+func TestStreamConsumerRequiresConsumerID(t *testing.T) {
+	client := &Client{}
+
+	err := client.Consume(AMQPConsumer{
+		QueueParams:    QueueParams{Name: "events"},
+		ConsumerParams: ConsumerParams{Stream: &StreamParams{}},
+	})
+	if err == nil {
+		t.Fatal("stream consumer without ConsumerID was accepted")
+	}
+	if len(client.consumers) != 0 {
+		t.Error("rejected consumer was registered")
+	}
+}